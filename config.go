@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/humblenginr/yt_rhymes_scraper/scraper"
+)
+
+// Config holds the tunables of a run. It is read from a JSON file; any field
+// the file leaves out keeps its default.
+type Config struct {
+	// Separation picks the Demucs stems and which of them is segmented.
+	Separation scraper.SeparationOptions `json:"separation"`
+	// VAD detects speech in the vocals before they are transcribed.
+	VAD        scraper.VADOptions        `json:"vad"`
+	Transcribe scraper.TranscribeOptions `json:"transcribe"`
+	Segment    scraper.SegmentOptions    `json:"segment"`
+	// Manifests lists the dataset manifest formats written at the end of a run.
+	Manifests []scraper.ManifestFormat `json:"manifests"`
+	// DuplicateThreshold is the fingerprint similarity at which a download is
+	// treated as a re-upload of a source in the run index and skipped; 0, the
+	// default, disables the check, which fingerprints every download.
+	DuplicateThreshold float64 `json:"duplicate_threshold"`
+	// Dedup drops near-duplicate segments across every source processed so
+	// far before manifests are written.
+	Dedup scraper.DedupOptions `json:"dedup"`
+	// Split additionally writes train/validation/test manifests when its by
+	// key is set.
+	Split scraper.SplitOptions `json:"split"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Separation: scraper.DefaultSeparationOptions(),
+		VAD:        scraper.DefaultVADOptions(),
+		Transcribe: scraper.DefaultTranscribeOptions(),
+		Segment:    scraper.DefaultSegmentOptions(),
+		Manifests:  []scraper.ManifestFormat{scraper.ManifestJSONL},
+		Dedup:      scraper.DefaultDedupOptions(),
+		Split:      scraper.DefaultSplitOptions(),
+	}
+}
+
+// loadConfig reads the JSON config at path on top of the defaults. An empty
+// path yields the defaults.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -5,4 +5,5 @@ go 1.24.2
 require (
 	github.com/cenkalti/backoff/v4 v4.3.0
 	github.com/tcolgate/mp3 v0.0.0-20170426193717-e79c5a46d300
+	golang.org/x/text v0.28.0
 )
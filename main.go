@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/humblenginr/yt_rhymes_scraper/dag"
@@ -17,15 +20,16 @@ const (
 
 type DownloadTask struct {
 	url     string
+	clip    *scraper.Clip
 	outFile string
-	retries uint
+	retries uint64
 	timeout time.Duration
 	cache   bool
 }
 
 func (t DownloadTask) ID() string             { return "download" }
 func (t DownloadTask) Deps() []string         { return nil }
-func (t DownloadTask) MaxRetries() uint       { return t.retries }
+func (t DownloadTask) MaxRetries() uint64     { return t.retries }
 func (t DownloadTask) Timeout() time.Duration { return t.timeout }
 func (t DownloadTask) Cacheable() bool        { return t.cache }
 func (t DownloadTask) Run(ctx context.Context, _ dag.Artifacts) (dag.Artifacts, error) {
@@ -33,6 +37,12 @@ func (t DownloadTask) Run(ctx context.Context, _ dag.Artifacts) (dag.Artifacts,
 		log.Printf("[download] cache hit -> %s", t.outFile)
 		return dag.Artifacts{"audio": t.outFile}, nil
 	}
+	if t.clip != nil {
+		if _, err := scraper.DownloadYoutubeClip(ctx, t.url, t.outFile, *t.clip); err != nil {
+			return nil, err
+		}
+		return dag.Artifacts{"audio": t.outFile}, nil
+	}
 	if _, err := scraper.DownloadYoutubeAudio(ctx, t.url, t.outFile); err != nil {
 		return nil, err
 	}
@@ -40,14 +50,14 @@ func (t DownloadTask) Run(ctx context.Context, _ dag.Artifacts) (dag.Artifacts,
 }
 
 type ExtractTask struct {
-	retries uint
+	retries uint64
 	timeout time.Duration
 	cache   bool
 }
 
 func (t ExtractTask) ID() string             { return "extract" }
 func (t ExtractTask) Deps() []string         { return []string{"download"} }
-func (t ExtractTask) MaxRetries() uint       { return t.retries }
+func (t ExtractTask) MaxRetries() uint64     { return t.retries }
 func (t ExtractTask) Timeout() time.Duration { return t.timeout }
 func (t ExtractTask) Cacheable() bool        { return t.cache }
 func (t ExtractTask) Run(ctx context.Context, in dag.Artifacts) (dag.Artifacts, error) {
@@ -60,85 +70,305 @@ func (t ExtractTask) Run(ctx context.Context, in dag.Artifacts) (dag.Artifacts,
 		return dag.Artifacts{"vocals": out}, nil
 	}
 
-	outPath, err := scraper.ExtractVocals(audio, dir)
+	vocals, err := scraper.ExtractVocals(ctx, &scraper.Audio{Path: audio, Format: scraper.FormatMP3}, dir)
+	if err != nil {
+		return nil, err
+	}
+	return dag.Artifacts{"vocals": vocals.Path}, nil
+}
+
+type VADTask struct {
+	retries uint64
+	timeout time.Duration
+	cache   bool
+	opts    scraper.VADOptions
+}
+
+func (t VADTask) ID() string             { return "vad" }
+func (t VADTask) Deps() []string         { return []string{"extract"} }
+func (t VADTask) MaxRetries() uint64     { return t.retries }
+func (t VADTask) Timeout() time.Duration { return t.timeout }
+func (t VADTask) Cacheable() bool        { return t.cache }
+func (t VADTask) Run(ctx context.Context, in dag.Artifacts) (dag.Artifacts, error) {
+	if !t.opts.Enabled {
+		return nil, nil
+	}
+
+	voc := &scraper.Audio{Path: in["vocals"], Format: scraper.FormatFromPath(in["vocals"])}
+	out := scraper.SpeechActivityPath(voc, filepath.Dir(voc.Path))
+
+	if _, err := os.Stat(out); err == nil && t.cache {
+		log.Printf("[vad] cache hit -> %s", out)
+		return dag.Artifacts{"speech": out}, nil
+	}
+
+	speech, err := scraper.DetectSpeech(ctx, voc, t.opts)
 	if err != nil {
 		return nil, err
 	}
-	return dag.Artifacts{"vocals": outPath}, nil
+	if err := scraper.WriteSpeechActivity(out, speech); err != nil {
+		return nil, err
+	}
+	return dag.Artifacts{"speech": out}, nil
 }
 
 type TranscribeTask struct {
-	retries uint
+	retries uint64
 	timeout time.Duration
 	cache   bool
+	opts    scraper.TranscribeOptions
+	// vad says whether the speech regions found by VADTask trim the vocals.
+	vad scraper.VADOptions
 }
 
 func (t TranscribeTask) ID() string             { return "transcribe" }
-func (t TranscribeTask) Deps() []string         { return []string{"extract"} }
-func (t TranscribeTask) MaxRetries() uint       { return t.retries }
+func (t TranscribeTask) Deps() []string         { return []string{"vad"} }
+func (t TranscribeTask) MaxRetries() uint64     { return t.retries }
 func (t TranscribeTask) Timeout() time.Duration { return t.timeout }
 func (t TranscribeTask) Cacheable() bool        { return t.cache }
-func (t TranscribeTask) Run(ctx context.Context, in Artifacts) (Artifacts, error) {
+func (t TranscribeTask) Run(ctx context.Context, in dag.Artifacts) (dag.Artifacts, error) {
 
-	voc := in["vocals"]
-	dir := filepath.Dir(voc)
-	out := filepath.Join(dir, "transcript.json")
+	voc := &scraper.Audio{Path: in["vocals"], Format: scraper.FormatFromPath(in["vocals"])}
+	dir := filepath.Dir(voc.Path)
+	out := scraper.TranscriptPath(voc, dir)
 
 	if _, err := os.Stat(out); err == nil && t.cache {
 		log.Printf("[transcribe] cache hit -> %s", out)
-		return Artifacts{"transcript": out}, nil
+		return dag.Artifacts{"transcript": out}, nil
 	}
 
-	jsonPath, err := scraper.Transcribe(voc, dir)
-	if err != nil {
+	opts := t.opts
+	if path := in["speech"]; path != "" && t.vad.Enabled && t.vad.TrimSilence {
+		speech, err := scraper.ReadSpeechActivity(path)
+		if err != nil {
+			return nil, err
+		}
+		opts.Speech = speech
+	}
+	if _, err := scraper.Transcribe(ctx, voc, dir, opts); err != nil {
 		return nil, err
 	}
-	return Artifacts{"transcript": jsonPath}, nil
+	return dag.Artifacts{"transcript": out}, nil
 }
 
 type SegmentTask struct {
-	retries uint
+	retries uint64
 	timeout time.Duration
+	opts    scraper.SegmentOptions
 }
 
 func (t SegmentTask) ID() string             { return "segment" }
 func (t SegmentTask) Deps() []string         { return []string{"transcribe"} }
-func (t SegmentTask) MaxRetries() uint       { return t.retries }
+func (t SegmentTask) MaxRetries() uint64     { return t.retries }
 func (t SegmentTask) Timeout() time.Duration { return t.timeout }
 func (t SegmentTask) Cacheable() bool        { return false }
-func (t SegmentTask) Run(ctx context.Context, in Artifacts) (Artifacts, error) {
+func (t SegmentTask) Run(ctx context.Context, in dag.Artifacts) (dag.Artifacts, error) {
 
-	voc := in["vocals"]
-	tr := in["transcript"]
+	voc := &scraper.Audio{Path: in["vocals"], Format: scraper.FormatFromPath(in["vocals"])}
+	tr, err := scraper.ReadTranscript(in["transcript"])
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := scraper.Segment(voc, tr)
+	opts := t.opts
+	if path := in["speech"]; path != "" {
+		if opts.Speech, err = scraper.ReadSpeechActivity(path); err != nil {
+			return nil, err
+		}
+	}
+	_, err = scraper.Segment(ctx, voc, tr, opts)
 	return nil, err
 }
 
 func main() {
-	artifactDirAbs, err := filepath.Abs(ArtifactDirectory)
+	url := flag.String("url", "https://www.youtube.com/watch?v=bQ8eDYWVzcU", "YouTube video to scrape")
+	start := flag.Duration("start", 0, "only download from this offset into the video")
+	end := flag.Duration("end", 0, "only download up to this offset into the video (0 = until the end)")
+	chapter := flag.String("chapter", "", "only download the chapter with this title")
+	byChapter := flag.Bool("chapters", false, "split the video on its chapter markers and process each chapter separately")
+	configPath := flag.String("config", "", "JSON file with pipeline settings")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
-	outPath := filepath.Join(artifactDirAbs, "audio.mp3")
 
-	a, err := scraper.DownloadYoutubeAudio("https://www.youtube.com/watch?v=bQ8eDYWVzcU", outPath)
+	artifactDirAbs, err := filepath.Abs(ArtifactDirectory)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
+	// Every source gets its own directory so the downloads, stems and
+	// transcripts of different videos or clips never mix.
+	clip := scraper.Clip{Start: *start, End: *end, Chapter: *chapter}
+	runDir := filepath.Join(artifactDirAbs, scraper.DownloadName(*url, clip))
+	outPath := filepath.Join(runDir, "audio.mp3")
 
-	vocals, err := scraper.ExtractVocals(a, artifactDirAbs)
+	var a *scraper.Audio
+	if clip != (scraper.Clip{}) {
+		a, err = scraper.DownloadYoutubeClip(ctx, *url, outPath, clip)
+	} else {
+		a, err = scraper.DownloadYoutubeAudio(ctx, *url, outPath)
+	}
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
 
-	transcription, err := scraper.Transcribe(vocals, artifactDirAbs)
+	// Every segment list records the video and channel, so that splits
+	// made by a later run group this run's segments correctly.
+	info, err := scraper.FetchVideoInfo(ctx, *url)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
+	a.Source.VideoID = info.ID
+	a.Source.Channel = info.ChannelID
 
-	segments, err := scraper.Segment(vocals, transcription)
+	var (
+		index *scraper.RunIndex
+		entry scraper.IndexEntry
+	)
+	if cfg.DuplicateThreshold > 0 {
+		index, err = scraper.OpenRunIndex(filepath.Join(artifactDirAbs, "index.json"))
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		fp, err := scraper.ComputeFingerprint(ctx, a.Path)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		entry = scraper.IndexEntry{
+			URL:         *url,
+			Clip:        clip,
+			VideoID:     a.Source.VideoID,
+			Duration:    a.Duration.Seconds(),
+			Fingerprint: fp,
+			AddedAt:     time.Now(),
+		}
+		if dup, sim := index.Lookup(*url, fp, cfg.DuplicateThreshold); dup != nil {
+			entry.DuplicateOf, entry.Similarity = dup.URL, sim
+			index.Add(entry)
+			if err := index.Save(); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			log.Printf("%s is a re-upload of %s (similarity %.2f), skipping", *url, dup.URL, sim)
+			return
+		}
+	}
+
+	var segments []scraper.AudioWithTranscript
+	if !*byChapter {
+		segments, err = process(ctx, cfg, a, runDir)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		log.Printf("produced %d segments from %s", len(segments), *url)
+	} else {
+		chapters, err := scraper.SplitChapters(ctx, a, info.Chapters, filepath.Join(runDir, "chapters"))
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		for _, ch := range chapters {
+			dir := strings.TrimSuffix(ch.Path, filepath.Ext(ch.Path))
+			chSegments, err := process(ctx, cfg, ch, dir)
+			if err != nil {
+				log.Fatalf("chapter %q: %v", ch.Source.Chapter, err)
+			}
+			log.Printf("produced %d segments from chapter %q", len(chSegments), ch.Source.Chapter)
+			segments = append(segments, chSegments...)
+		}
+	}
+
+	// The manifests and splits cover every source processed so far, not just
+	// this run's.
+	if err := scraper.WriteSegmentList(filepath.Join(runDir, scraper.SegmentListFile), segments); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	dataset, err := scraper.CollectSegments(artifactDirAbs)
 	if err != nil {
 		log.Fatalf("error: %v", err)
 	}
+	log.Printf("dataset has %d segments", len(dataset))
+
+	// Dedup runs over the whole dataset, so a segment is compared against
+	// those of earlier videos too; the lists keep every segment, so what
+	// is dropped is decided afresh as sources are added.
+	if cfg.Dedup.MaxPerCluster > 0 {
+		var report *scraper.DedupReport
+		dataset, report, err = scraper.Dedup(ctx, dataset, cfg.Dedup)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if err := scraper.WriteDedupReport(filepath.Join(artifactDirAbs, "dedup.json"), report); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		log.Printf("dedup kept %d of %d segments", report.Kept, report.Total)
+	}
+
+	if err := scraper.WriteManifests(artifactDirAbs, cfg.Manifests, dataset); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if index != nil {
+		index.Add(entry)
+		if err := index.Save(); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+	if cfg.Split.By != "" {
+		summary, err := scraper.WriteSplits(artifactDirAbs, cfg.Manifests, dataset, cfg.Split)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		for _, s := range []scraper.DatasetSplit{scraper.SplitTrain, scraper.SplitValidation, scraper.SplitTest} {
+			st := summary.Splits[s]
+			log.Printf("%s split: %d segments from %d groups, %.1fs", s, st.Items, st.Groups, st.Seconds)
+		}
+	}
+}
+
+// process runs stem separation, speech detection and transcription of the
+// vocals and segmentation of the configured stem on a, keeping all
+// intermediate artifacts in dir.
+func process(ctx context.Context, cfg Config, a *scraper.Audio, dir string) ([]scraper.AudioWithTranscript, error) {
+	stems, err := scraper.Separate(ctx, a, dir, cfg.Separation)
+	if err != nil {
+		return nil, err
+	}
+	vocals := stems[scraper.StemVocals]
+	target, ok := stems[cfg.Separation.SegmentStem]
+	if !ok {
+		return nil, fmt.Errorf("separation mode %q has no %q stem to segment", cfg.Separation.Mode, cfg.Separation.SegmentStem)
+	}
+
+	var speech *scraper.SpeechActivity
+	if cfg.VAD.Enabled {
+		if speech, err = scraper.DetectSpeech(ctx, vocals, cfg.VAD); err != nil {
+			return nil, err
+		}
+		if err := scraper.WriteSpeechActivity(scraper.SpeechActivityPath(vocals, dir), speech); err != nil {
+			return nil, err
+		}
+		log.Printf("detected %.1fs of speech in %d regions", speech.SpeechSeconds(), len(speech.Regions))
+	}
+
+	transcribeOpts := cfg.Transcribe
+	if cfg.VAD.TrimSilence {
+		transcribeOpts.Speech = speech
+	}
+	transcription, err := scraper.Transcribe(ctx, vocals, dir, transcribeOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// whisperx writes its own plain .srt/.vtt next to the transcript; keep ours apart
+	base := strings.TrimSuffix(scraper.TranscriptPath(vocals, dir), ".json") + ".aligned"
+	if _, err := scraper.ExportSubtitles(transcription, base); err != nil {
+		return nil, err
+	}
+
+	opts := cfg.Segment
+	opts.Accompaniment = stems[scraper.StemAccompaniment]
+	opts.Speech = speech
+	return scraper.Segment(ctx, target, transcription, opts)
 }
@@ -0,0 +1,113 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SplitChapters cuts audio into one MP3 per chapter and writes them to
+// outputDir. Chapter times are in the source video's timeline, so audio may
+// itself be a clip: chapters outside it are dropped and partially covered
+// ones are trimmed to the part that was downloaded. Each returned Audio is
+// tagged with its chapter title and offset within the source video.
+func SplitChapters(
+	ctx context.Context,
+	audio *Audio,
+	chapters []Chapter,
+	outputDir string,
+) ([]*Audio, error) {
+	if audio == nil {
+		return nil, errors.New("input audio is nil")
+	}
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("%s has no chapter markers", audio.Source.URL)
+	}
+	// Chapter files are named after the video so that a directory shared
+	// between runs never hands out another video's chapters.
+	id := audio.Source.VideoID
+	if id == "" {
+		id = DownloadName(audio.Source.URL, Clip{})
+	}
+
+	absOut, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("abs output dir: %w", err)
+	}
+	if err := os.MkdirAll(absOut, fs.ModePerm); err != nil {
+		return nil, fmt.Errorf("mkdir output dir: %w", err)
+	}
+
+	clipStart := audio.Source.Offset.Seconds()
+	clipEnd := clipStart + audio.Duration.Seconds()
+
+	var result []*Audio
+	for i, ch := range chapters {
+		start := max(ch.Start, clipStart)
+		end := min(ch.End, clipEnd)
+		if end <= start {
+			log.Printf("Warning: Chapter %d (%q) is outside the downloaded audio. Skipping.", i, ch.Title)
+			continue
+		}
+
+		outPath := filepath.Join(absOut, fmt.Sprintf("%s_chapter_%03d.mp3", id, i))
+		source := audio.Source
+		source.Offset = secondsToDuration(start)
+		source.Chapter = ch.Title
+
+		if _, err := os.Stat(outPath); err != nil {
+			if err := cutMP3(ctx, audio.Path, outPath, start-clipStart, end-clipStart); err != nil {
+				return nil, fmt.Errorf("chapter %d: %w", i, err)
+			}
+		}
+
+		dur, err := Mp3Duration(outPath)
+		if err != nil {
+			return nil, fmt.Errorf("chapter %d duration: %w", i, err)
+		}
+		result = append(result, &Audio{
+			Path:     outPath,
+			Duration: dur,
+			Format:   FormatMP3,
+			Source:   source,
+		})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("none of the %d chapters of %s overlap the downloaded audio", len(chapters), audio.Source.URL)
+	}
+	return result, nil
+}
+
+// cutMP3 re-encodes [start, end) seconds of src into dst via a temp file, so
+// an interrupted run never leaves a truncated dst behind.
+func cutMP3(ctx context.Context, src, dst string, start, end float64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "*.tmp.mp3")
+	if err != nil {
+		return fmt.Errorf("create temp mp3: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-i", src,
+		"-ss", fmt.Sprintf("%f", start),
+		"-to", fmt.Sprintf("%f", end),
+		"-acodec", "libmp3lame",
+		"-q:a", "0",
+		tmp.Name(),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w – %s", err, out)
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("rename mp3: %w", err)
+	}
+	return nil
+}
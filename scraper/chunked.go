@@ -0,0 +1,424 @@
+package scraper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// audioChunk is one [Start, End) range of a longer recording, in seconds.
+type audioChunk struct {
+	Start float64
+	End   float64
+}
+
+// planChunks covers [0, duration) with chunks of about chunkSeconds that
+// overlap their successor by overlapSeconds. A recording that fits in one
+// chunk is returned as a single chunk.
+func planChunks(duration, chunkSeconds, overlapSeconds float64) []audioChunk {
+	if chunkSeconds <= 0 || duration <= chunkSeconds+overlapSeconds {
+		return []audioChunk{{Start: 0, End: duration}}
+	}
+	step := chunkSeconds
+	var chunks []audioChunk
+	for start := 0.0; start < duration; start += step {
+		end := min(start+chunkSeconds+overlapSeconds, duration)
+		// Fold a short remainder into this chunk rather than separating a
+		// sliver on its own.
+		if duration-end < overlapSeconds {
+			end = duration
+		}
+		chunks = append(chunks, audioChunk{Start: start, End: end})
+		if end == duration {
+			break
+		}
+	}
+	return chunks
+}
+
+// separateChunked runs Demucs on the chunks of src, at most workers at a
+// time, and crossfades each stem's chunks back together into
+// stemDir/<file>.wav, where Demucs would have put the stems of the whole
+// recording.
+func separateChunked(ctx context.Context, src *Audio, chunks []audioChunk, workers int, model string, modeArgs []string,
+	stemFiles map[string]string, separatedDir, stemDir string) error {
+	workers = max(workers, 1)
+	chunkDir := filepath.Join(separatedDir, "chunks")
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return fmt.Errorf("mkdir chunk dir: %w", err)
+	}
+
+	base := filepath.Base(stemDir)
+	spans := make([]cutSpan, len(chunks))
+	for i, c := range chunks {
+		spans[i] = cutSpan{Start: c.Start, End: c.End, Path: filepath.Join(chunkDir, fmt.Sprintf("%s_chunk_%03d.wav", base, i))}
+	}
+	for i, err := range cutSpans(ctx, src, spans, OutputOptions{Format: FormatWAV}) {
+		if err != nil {
+			return fmt.Errorf("cut chunk %d: %w", i, err)
+		}
+	}
+
+	log.Printf("separating %d chunks with Demucs (%s), %d at a time", len(chunks), model, workers)
+
+	sem := make(chan struct{}, workers)
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+	for i := range chunks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			if err := runDemucs(ctx, model, modeArgs, separatedDir, spans[i].Path); err != nil {
+				errs[i] = fmt.Errorf("chunk %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(stemDir, 0755); err != nil {
+		return fmt.Errorf("mkdir stem dir: %w", err)
+	}
+	for _, file := range stemFiles {
+		parts := make([]string, len(chunks))
+		for i := range chunks {
+			chunkBase := fmt.Sprintf("%s_chunk_%03d", base, i)
+			parts[i] = filepath.Join(separatedDir, model, chunkBase, file+".wav")
+		}
+		if err := stitchWAVs(parts, chunks, filepath.Join(stemDir, file+".wav")); err != nil {
+			return fmt.Errorf("stitch %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// stitchWAVs joins the WAVs at paths, which hold the audio of the
+// overlapping chunks, into one WAV at dst. Where two chunks overlap the
+// earlier one fades out linearly while the later one fades in.
+func stitchWAVs(paths []string, chunks []audioChunk, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	var w *wavWriter
+	err = func() error {
+		var (
+			tail     []float32 // end of the previous chunk that overlaps this one
+			channels int
+			rate     int
+		)
+		for i, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			st, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return err
+			}
+			r, err := newWAVReader(f, st.Size())
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if w == nil {
+				channels, rate = r.Channels, r.SampleRate
+				if w, err = newWAVWriter(out, rate, channels); err != nil {
+					f.Close()
+					return err
+				}
+			} else if r.Channels != channels || r.SampleRate != rate {
+				f.Close()
+				return fmt.Errorf("%s: %d Hz, %d channels does not match %d Hz, %d channels", path, r.SampleRate, r.Channels, rate, channels)
+			}
+
+			frames := r.Frames()
+			fadeIn := min(int64(len(tail)/channels), frames)
+			var holdBack int64
+			if i+1 < len(paths) {
+				overlap := int64(math.Round((chunks[i].End - chunks[i+1].Start) * float64(rate)))
+				holdBack = min(max(overlap, 0), frames-fadeIn)
+			}
+
+			// Crossfade the start of this chunk into the previous tail.
+			head := make([]float32, fadeIn*int64(channels))
+			n, err := r.ReadFrames(head)
+			if err != nil && err != io.EOF {
+				f.Close()
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			for k := 0; k < n; k++ {
+				g := (float32(k) + 0.5) / float32(fadeIn)
+				for c := 0; c < channels; c++ {
+					j := k*channels + c
+					head[j] = tail[j]*(1-g) + head[j]*g
+				}
+			}
+			if err := w.WriteFrames(head[:n*channels]); err != nil {
+				f.Close()
+				return err
+			}
+
+			// Copy the body and keep the part the next chunk overlaps.
+			body := frames - fadeIn - holdBack
+			buf := make([]float32, cutFrameBlock*channels)
+			for body > 0 {
+				n, err := r.ReadFrames(buf[:min(int64(cutFrameBlock), body)*int64(channels)])
+				if n > 0 {
+					if werr := w.WriteFrames(buf[:n*channels]); werr != nil {
+						f.Close()
+						return werr
+					}
+					body -= int64(n)
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					f.Close()
+					return fmt.Errorf("%s: %w", path, err)
+				}
+			}
+			tail = make([]float32, holdBack*int64(channels))
+			n, err = r.ReadFrames(tail)
+			tail = tail[:n*channels]
+			f.Close()
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if w == nil {
+			return errors.New("no chunks to stitch")
+		}
+		return w.Close()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// transcribeHopSeconds: Resolution of the envelope used to find quiet points to cut the vocals at
+const transcribeHopSeconds = 0.05
+
+// planTranscribeChunks splits vocals into consecutive regions of about
+// opts.ChunkSeconds, each ending at the quietest point within
+// opts.SearchSeconds of its nominal end. The regions do not overlap; the
+// overlap is added when the chunks are cut.
+func planTranscribeChunks(ctx context.Context, vocals *Audio, opts TranscribeOptions) ([]audioChunk, error) {
+	env, err := rmsEnvelope(ctx, vocals.Path, transcribeHopSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("envelope %s: %w", vocals.Path, err)
+	}
+	duration := float64(len(env.RMS)) * env.Hop
+	search := max(opts.SearchSeconds, 0)
+
+	var chunks []audioChunk
+	start := 0.0
+	for start+opts.ChunkSeconds+search < duration {
+		nominal := start + opts.ChunkSeconds
+		end := env.quietest(nominal-search, nominal+search, nominal)
+		if end <= start {
+			end = nominal
+		}
+		chunks = append(chunks, audioChunk{Start: start, End: end})
+		start = end
+	}
+	return append(chunks, audioChunk{Start: start, End: duration}), nil
+}
+
+// transcribeChunked cuts vocals into the regions of chunks, widened by
+// opts.OverlapSeconds, transcribes them with whisperx at most opts.Workers
+// at a time and merges the transcripts back onto the timeline of vocals.
+// Chunks are kept in a directory keyed on the content of vocals and the
+// chunk bounds, so a rerun on the same input resumes where it stopped while
+// a new input or chunking never picks up stale transcripts.
+func transcribeChunked(ctx context.Context, vocals *Audio, chunks []audioChunk, artifactsDir string, opts TranscribeOptions) (*TimeAlignedTranscript, error) {
+	overlap := max(opts.OverlapSeconds, 0)
+	spans := make([]cutSpan, len(chunks))
+	for i, c := range chunks {
+		spans[i] = cutSpan{Start: max(c.Start-overlap, 0), End: c.End + overlap}
+	}
+	if i := len(chunks) - 1; spans[i].End > chunks[i].End {
+		spans[i].End = chunks[i].End
+	}
+
+	key, err := chunkCacheKey(vocals.Path, spans)
+	if err != nil {
+		return nil, err
+	}
+	chunkDir := filepath.Join(artifactsDir, "transcribe_chunks", key)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return nil, fmt.Errorf("mkdir chunk dir: %w", err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(vocals.Path), filepath.Ext(vocals.Path))
+	var pending []int
+	for i := range spans {
+		spans[i].Path = filepath.Join(chunkDir, fmt.Sprintf("%s_chunk_%03d.wav", base, i))
+		if _, err := os.Stat(TranscriptPath(&Audio{Path: spans[i].Path}, chunkDir)); err != nil {
+			pending = append(pending, i)
+		}
+	}
+
+	if len(pending) > 0 {
+		toCut := make([]cutSpan, len(pending))
+		for k, i := range pending {
+			toCut[k] = spans[i]
+		}
+		for k, err := range cutSpans(ctx, vocals, toCut, OutputOptions{Format: FormatWAV}) {
+			if err != nil {
+				return nil, fmt.Errorf("cut chunk %d: %w", pending[k], err)
+			}
+		}
+
+		workers := max(opts.Workers, 1)
+		log.Printf("transcribing %d chunks with whisperx, %d at a time", len(pending), workers)
+
+		sem := make(chan struct{}, workers)
+		errs := make([]error, len(pending))
+		var wg sync.WaitGroup
+		for k, i := range pending {
+			wg.Add(1)
+			go func(k, i int) {
+				defer wg.Done()
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					errs[k] = ctx.Err()
+					return
+				}
+				defer func() { <-sem }()
+				if err := runWhisperX(ctx, spans[i].Path, chunkDir); err != nil {
+					errs[k] = fmt.Errorf("chunk %d: %w", i, err)
+				}
+			}(k, i)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+	}
+
+	parts := make([]*TimeAlignedTranscript, len(chunks))
+	for i := range chunks {
+		tat, err := ReadTranscript(TranscriptPath(&Audio{Path: spans[i].Path}, chunkDir))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		parts[i] = tat
+	}
+	return mergeTranscripts(parts, chunks, spans), nil
+}
+
+// mergeTranscripts joins the transcripts of the chunks, whose audio covers
+// spans, into one transcript on the timeline of the whole recording. A
+// segment belongs to the chunk whose region holds its midpoint, so each one
+// is taken from a single chunk; words of a chunk that start before the end
+// of the last word kept from the chunks before it repeat the overlap and are
+// dropped, wherever they are in their segment. The language is the one most
+// chunks were detected in.
+func mergeTranscripts(parts []*TimeAlignedTranscript, chunks []audioChunk, spans []cutSpan) *TimeAlignedTranscript {
+	merged := &TimeAlignedTranscript{}
+	languages := make(map[string]int)
+	lastEnd := 0.0 // end of the last timed word merged so far
+	for i, tat := range parts {
+		languages[tat.Language]++
+		offset := spans[i].Start
+		// Words of this chunk starting before this are in the overlap with
+		// earlier chunks and have been merged already.
+		prevEnd := lastEnd
+		for _, seg := range tat.Segments {
+			seg.Start += offset
+			seg.End += offset
+			mid := (seg.Start + seg.End) / 2
+			if mid < chunks[i].Start || (mid >= chunks[i].End && i+1 < len(chunks)) {
+				continue
+			}
+
+			words := make([]TimeAlignedWord, 0, len(seg.Words))
+			dropped := false
+			for _, w := range seg.Words {
+				if w.End > w.Start {
+					w.Start += offset
+					w.End += offset
+					if w.Start < prevEnd {
+						dropped = true
+						continue
+					}
+				}
+				words = append(words, w)
+			}
+			if len(words) == 0 {
+				continue
+			}
+			seg.Words = words
+			if dropped {
+				text := make([]string, len(words))
+				for k, w := range words {
+					text[k] = strings.TrimSpace(w.Word)
+				}
+				seg.Text = strings.Join(text, " ")
+				seg.Start = max(seg.Start, prevEnd)
+				for _, w := range words {
+					if w.End > w.Start {
+						seg.Start = w.Start
+						break
+					}
+				}
+			}
+			for _, w := range words {
+				if w.End > w.Start {
+					lastEnd = max(lastEnd, w.End)
+				}
+			}
+			merged.Segments = append(merged.Segments, seg)
+		}
+	}
+	for lang, n := range languages {
+		if lang != "" && (n > languages[merged.Language] || (n == languages[merged.Language] && lang < merged.Language)) {
+			merged.Language = lang
+		}
+	}
+	return merged
+}
+
+// chunkCacheKey identifies the chunks spans of the audio at path by a digest
+// of the audio's content and the chunk bounds.
+func chunkCacheKey(path string, spans []cutSpan) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	for _, sp := range spans {
+		fmt.Fprintf(h, "%.6f-%.6f\n", sp.Start, sp.End)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
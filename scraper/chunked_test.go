@@ -0,0 +1,274 @@
+package scraper
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+	tests := []struct {
+		name                            string
+		duration, chunkSeconds, overlap float64
+		want                            []audioChunk
+	}{
+		{name: "chunking disabled", duration: 100, chunkSeconds: 0, overlap: 5, want: []audioChunk{{0, 100}}},
+		{name: "fits in one chunk", duration: 64, chunkSeconds: 60, overlap: 5, want: []audioChunk{{0, 64}}},
+		{
+			name:     "overlapping chunks",
+			duration: 200, chunkSeconds: 60, overlap: 5,
+			want: []audioChunk{{0, 65}, {60, 125}, {120, 185}, {180, 200}},
+		},
+		{
+			name:     "short remainder is folded into the last chunk",
+			duration: 188, chunkSeconds: 60, overlap: 5,
+			want: []audioChunk{{0, 65}, {60, 125}, {120, 188}},
+		},
+		{
+			name:     "no overlap",
+			duration: 30, chunkSeconds: 10, overlap: 0,
+			want: []audioChunk{{0, 10}, {10, 20}, {20, 30}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planChunks(tt.duration, tt.chunkSeconds, tt.overlap); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planChunks(%g, %g, %g) = %v, want %v", tt.duration, tt.chunkSeconds, tt.overlap, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeTestWAV(t *testing.T, path string, rate, channels int, samples []float32) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWAVWriter(f, rate, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrames(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestWAV(t *testing.T, path string) (samples []float32, rate, channels int) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newWAVReader(f, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples = make([]float32, r.Frames()*int64(r.Channels))
+	n, err := r.ReadFrames(samples)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return samples[:n*r.Channels], r.SampleRate, r.Channels
+}
+
+// TestStitchWAVs cuts a signal into overlapping chunks as separateChunked
+// does and checks that stitching restores it: the crossfade gains of two
+// chunks sum to one, so identical overlaps come back unchanged.
+func TestStitchWAVs(t *testing.T) {
+	const rate = 1000
+	tests := []struct {
+		name     string
+		channels int
+		duration float64
+		chunk    float64
+		overlap  float64
+	}{
+		{name: "mono", channels: 1, duration: 10, chunk: 3, overlap: 0.5},
+		{name: "stereo", channels: 2, duration: 7.3, chunk: 2, overlap: 0.25},
+		{name: "no overlap", channels: 1, duration: 4, chunk: 1, overlap: 0},
+		{name: "single chunk", channels: 2, duration: 2, chunk: 0, overlap: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			frames := int(math.Round(tt.duration * rate))
+			signal := make([]float32, frames*tt.channels)
+			for i := range signal {
+				// Stay on the 16 bit grid so the WAV round trip is exact.
+				signal[i] = float32(int(8000*math.Sin(float64(i)*0.01))) / (1 << 15)
+			}
+
+			chunks := planChunks(tt.duration, tt.chunk, tt.overlap)
+			paths := make([]string, len(chunks))
+			for i, c := range chunks {
+				lo := int(math.Round(c.Start*rate)) * tt.channels
+				hi := int(math.Round(c.End*rate)) * tt.channels
+				paths[i] = filepath.Join(dir, fmt.Sprintf("chunk_%03d.wav", i))
+				writeTestWAV(t, paths[i], rate, tt.channels, signal[lo:hi])
+			}
+
+			dst := filepath.Join(dir, "stitched.wav")
+			if err := stitchWAVs(paths, chunks, dst); err != nil {
+				t.Fatalf("stitchWAVs: %v", err)
+			}
+			got, gotRate, gotChannels := readTestWAV(t, dst)
+			if gotRate != rate || gotChannels != tt.channels {
+				t.Fatalf("stitched %d Hz, %d channels, want %d Hz, %d channels", gotRate, gotChannels, rate, tt.channels)
+			}
+			if len(got) != len(signal) {
+				t.Fatalf("stitched %d samples, want %d", len(got), len(signal))
+			}
+			for i := range got {
+				if math.Abs(float64(got[i]-signal[i])) > 1.0/(1<<15) {
+					t.Fatalf("sample %d = %g, want %g", i, got[i], signal[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStitchWAVsMismatchedChunks(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav")
+	writeTestWAV(t, a, 1000, 1, make([]float32, 1000))
+	writeTestWAV(t, b, 2000, 1, make([]float32, 2000))
+	dst := filepath.Join(dir, "out.wav")
+	if err := stitchWAVs([]string{a, b}, []audioChunk{{0, 1}, {1, 2}}, dst); err == nil {
+		t.Fatal("stitchWAVs of chunks with different rates succeeded")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("failed stitch left %s behind", dst)
+	}
+}
+
+func TestMergeTranscripts(t *testing.T) {
+	word := func(start, end float64, w string) TimeAlignedWord {
+		return TimeAlignedWord{Start: start, End: end, Word: w, ConfidenceScore: 0.9}
+	}
+	seg := func(text string, start, end float64, words ...TimeAlignedWord) TranscriptSegment {
+		return TranscriptSegment{Text: text, Start: start, End: end, Words: words}
+	}
+	// Two chunks split at 30s, each cut with 1s of overlap.
+	twoChunks := []audioChunk{{0, 30}, {30, 60}}
+	twoSpans := []cutSpan{{Start: 0, End: 31}, {Start: 29, End: 60}}
+
+	tests := []struct {
+		name   string
+		parts  []*TimeAlignedTranscript
+		chunks []audioChunk
+		spans  []cutSpan
+		want   *TimeAlignedTranscript
+	}{
+		{
+			name:   "single chunk is taken as is",
+			parts:  []*TimeAlignedTranscript{{Language: "en", Segments: []TranscriptSegment{seg("a b", 1, 3, word(1, 2, "a"), word(2, 3, "b"))}}},
+			chunks: []audioChunk{{0, 10}},
+			spans:  []cutSpan{{Start: 0, End: 10}},
+			want:   &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{seg("a b", 1, 3, word(1, 2, "a"), word(2, 3, "b"))}},
+		},
+		{
+			name: "later chunks are shifted by their span start",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en", Segments: []TranscriptSegment{seg("a", 5, 6, word(5, 6, "a"))}},
+				{Language: "en", Segments: []TranscriptSegment{seg("b", 10, 12, word(10, 12, "b"))}},
+			},
+			chunks: twoChunks,
+			spans:  twoSpans,
+			want: &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+				seg("a", 5, 6, word(5, 6, "a")),
+				seg("b", 39, 41, word(39, 41, "b")),
+			}},
+		},
+		{
+			name: "words repeated at the start of the next chunk are dropped",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en", Segments: []TranscriptSegment{seg("a b", 1, 30.5, word(1, 2, "a"), word(29, 30.5, "b"))}},
+				{Language: "en", Segments: []TranscriptSegment{seg("b c", 0.2, 5, word(0.2, 1.5, "b"), word(3, 5, "c"))}},
+			},
+			chunks: twoChunks,
+			spans:  twoSpans,
+			want: &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+				seg("a b", 1, 30.5, word(1, 2, "a"), word(29, 30.5, "b")),
+				seg("c", 32, 34, word(32, 34, "c")),
+			}},
+		},
+		{
+			name: "repeats after an unaligned word are dropped too",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en", Segments: []TranscriptSegment{seg("a 5 b", 1, 30.5, word(1, 2, "a"), word(0, 0, "5"), word(29, 30.5, "b"))}},
+				{Language: "en", Segments: []TranscriptSegment{seg("5 b c", 0.2, 5, word(0, 0, "5"), word(0.2, 1.5, "b"), word(3, 5, "c"))}},
+			},
+			chunks: twoChunks,
+			spans:  twoSpans,
+			want: &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+				seg("a 5 b", 1, 30.5, word(1, 2, "a"), word(0, 0, "5"), word(29, 30.5, "b")),
+				seg("5 c", 32, 34, word(0, 0, "5"), word(32, 34, "c")),
+			}},
+		},
+		{
+			name: "a segment belongs to the chunk holding its midpoint",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en", Segments: []TranscriptSegment{
+					seg("a", 10, 11, word(10, 11, "a")),
+					seg("x y", 29.5, 31, word(29.5, 30.2, "x"), word(30.4, 31, "y")),
+				}},
+				{Language: "en", Segments: []TranscriptSegment{seg("x y z", 0.5, 4, word(0.5, 1.2, "x"), word(1.4, 2, "y"), word(3, 4, "z"))}},
+			},
+			chunks: twoChunks,
+			spans:  twoSpans,
+			want: &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+				seg("a", 10, 11, word(10, 11, "a")),
+				seg("x y z", 29.5, 33, word(29.5, 30.2, "x"), word(30.4, 31, "y"), word(32, 33, "z")),
+			}},
+		},
+		{
+			name: "segments made only of repeats disappear",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en", Segments: []TranscriptSegment{seg("a b", 20, 30.8, word(20, 21, "a"), word(30, 30.8, "b"))}},
+				{Language: "en", Segments: []TranscriptSegment{
+					seg("b", 1, 1.8, word(1, 1.8, "b")),
+					seg("c", 5, 6, word(5, 6, "c")),
+				}},
+			},
+			chunks: twoChunks,
+			spans:  twoSpans,
+			want: &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+				seg("a b", 20, 30.8, word(20, 21, "a"), word(30, 30.8, "b")),
+				seg("c", 34, 35, word(34, 35, "c")),
+			}},
+		},
+		{
+			name: "the most common language wins, ties alphabetically",
+			parts: []*TimeAlignedTranscript{
+				{Language: "en"}, {Language: "de"}, {Language: "de"}, {Language: "en"}, {Language: ""},
+			},
+			chunks: []audioChunk{{0, 1}, {1, 2}, {2, 3}, {3, 4}, {4, 5}},
+			spans:  []cutSpan{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 4}, {Start: 4, End: 5}},
+			want:   &TimeAlignedTranscript{Language: "de"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeTranscripts(tt.parts, tt.chunks, tt.spans)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeTranscripts =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
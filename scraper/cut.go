@@ -0,0 +1,325 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// cutFrameBlock: Number of sample frames decoded per read while cutting
+const cutFrameBlock = 4096
+
+// cutSpan is one [Start, End) range of the source, in seconds, to be written
+// to Path.
+type cutSpan struct {
+	Start float64
+	End   float64
+	Path  string
+}
+
+// OutputOptions selects the encoding of cut segments.
+type OutputOptions struct {
+	// Format is one of FormatWAV (PCM16), FormatFLAC, FormatMP3 or FormatOpus.
+	Format Format `json:"format"`
+	// SampleRate resamples the output; 0 keeps the input's rate.
+	SampleRate int `json:"sample_rate"`
+	// Mono downmixes the output to a single channel.
+	Mono bool `json:"mono"`
+	// LoudnessLUFS normalizes each segment to this EBU R128 integrated
+	// loudness, e.g. -23; 0 leaves levels alone.
+	LoudnessLUFS float64 `json:"loudness_lufs"`
+}
+
+// Ext is the file extension, with dot, for o.Format.
+func (o OutputOptions) Ext() string {
+	return "." + string(o.Format)
+}
+
+func (o OutputOptions) validate() error {
+	switch o.Format {
+	case FormatWAV, FormatFLAC, FormatMP3, FormatOpus:
+	default:
+		return fmt.Errorf("unsupported output format %q", o.Format)
+	}
+	if o.SampleRate < 0 {
+		return fmt.Errorf("invalid output sample rate %d", o.SampleRate)
+	}
+	if o.LoudnessLUFS > 0 {
+		return fmt.Errorf("loudness target must be negative LUFS, got %.1f", o.LoudnessLUFS)
+	}
+	if o.Format == FormatOpus && o.SampleRate != 0 {
+		switch o.SampleRate {
+		case 8000, 12000, 16000, 24000, 48000:
+		default:
+			return fmt.Errorf("opus does not support a sample rate of %d Hz, use 8, 12, 16, 24 or 48 kHz", o.SampleRate)
+		}
+	}
+	return nil
+}
+
+// native reports whether the native cutter can produce o: it only writes
+// PCM16 WAV and can not resample or measure loudness.
+func (o OutputOptions) native() bool {
+	return o.Format == FormatWAV && o.LoudnessLUFS == 0
+}
+
+// ffmpegArgs are the output options that make ffmpeg produce o.
+func (o OutputOptions) ffmpegArgs() []string {
+	var args []string
+	if o.SampleRate > 0 {
+		args = append(args, "-ar", fmt.Sprint(o.SampleRate))
+	}
+	if o.Mono {
+		args = append(args, "-ac", "1")
+	}
+	if o.LoudnessLUFS != 0 {
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%.1f:TP=-1.5:LRA=11", o.LoudnessLUFS))
+	}
+	switch o.Format {
+	case FormatWAV:
+		args = append(args, "-c:a", "pcm_s16le")
+	case FormatFLAC:
+		args = append(args, "-c:a", "flac")
+	case FormatMP3:
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+	case FormatOpus:
+		args = append(args, "-c:a", "libopus", "-b:a", "96k")
+	}
+	return args
+}
+
+// cutSpans writes every span of src to its own file, encoded as described by
+// out. WAV sources are cut natively in a single pass over the file when out
+// allows it; anything else, or a WAV the native decoder can not handle, goes
+// through one ffmpeg process per span. In the pipeline the native path is
+// taken for stems stored as WAV, the SeparationOptions default; MP3 or FLAC
+// stems and downloads are always cut by ffmpeg. The returned slice holds the
+// error for each span, or nil if it was written.
+func cutSpans(ctx context.Context, src *Audio, spans []cutSpan, out OutputOptions) []error {
+	if src.Format == FormatWAV && out.native() {
+		errs, err := cutWAV(ctx, src.Path, spans, out)
+		if err == nil {
+			return errs
+		}
+		if !errors.Is(err, errUnsupportedWAV) {
+			return repeatErr(err, len(spans))
+		}
+	}
+
+	// loudnorm upsamples to 192 kHz, so pin the rate: the source's, or for
+	// Opus, which only takes a few rates, its native 48 kHz.
+	if out.LoudnessLUFS != 0 && out.SampleRate == 0 {
+		if out.Format == FormatOpus {
+			out.SampleRate = 48000
+		} else {
+			info, err := Probe(ctx, src.Path)
+			if err != nil {
+				return repeatErr(fmt.Errorf("probe sample rate for loudness normalization: %w", err), len(spans))
+			}
+			out.SampleRate = info.SampleRate
+		}
+	}
+
+	errs := make([]error, len(spans))
+	for i, sp := range spans {
+		errs[i] = cutFFmpeg(ctx, src.Path, sp, out)
+	}
+	return errs
+}
+
+var errUnsupportedWAV = errors.New("unsupported wav")
+
+// cutWAV decodes src once and streams each frame into every span that covers
+// it. Boundaries are rounded to the nearest sample frame. The returned error
+// wraps errUnsupportedWAV if src can not be decoded natively or would need
+// resampling.
+func cutWAV(ctx context.Context, src string, spans []cutSpan, out OutputOptions) ([]error, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	wr, err := newWAVReader(f, st.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedWAV, err)
+	}
+	if out.SampleRate != 0 && out.SampleRate != wr.SampleRate {
+		return nil, fmt.Errorf("%w: resampling %d Hz to %d Hz", errUnsupportedWAV, wr.SampleRate, out.SampleRate)
+	}
+	outChannels := wr.Channels
+	if out.Mono {
+		outChannels = 1
+	}
+
+	type job struct {
+		idx        int
+		start, end int64
+		out        *os.File
+		w          *wavWriter
+	}
+	rate := float64(wr.SampleRate)
+	jobs := make([]*job, len(spans))
+	for i, sp := range spans {
+		jobs[i] = &job{
+			idx:   i,
+			start: int64(math.Round(sp.Start * rate)),
+			end:   min(int64(math.Round(sp.End*rate)), wr.Frames()),
+		}
+	}
+	order := make([]*job, len(jobs))
+	copy(order, jobs)
+	sort.Slice(order, func(a, b int) bool { return order[a].start < order[b].start })
+
+	errs := make([]error, len(spans))
+	finish := func(j *job, err error) {
+		if j.w != nil && err == nil {
+			err = j.w.Close()
+		}
+		if j.out != nil {
+			if cerr := j.out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(j.out.Name())
+			}
+		}
+		errs[j.idx] = err
+	}
+
+	var (
+		active []*job
+		next   int
+		pos    int64
+		buf    = make([]float32, cutFrameBlock*wr.Channels)
+		mono   []float32
+	)
+	for next < len(order) || len(active) > 0 {
+		if err := ctx.Err(); err != nil {
+			for _, j := range active {
+				finish(j, err)
+			}
+			for _, j := range order[next:] {
+				errs[j.idx] = err
+			}
+			return errs, nil
+		}
+
+		n, rerr := wr.ReadFrames(buf)
+		blockEnd := pos + int64(n)
+		frames := buf
+		if outChannels != wr.Channels {
+			mono = downmix(mono, buf[:n*wr.Channels], wr.Channels)
+			frames = mono
+		}
+
+		// Open writers for spans starting inside this block.
+		for next < len(order) && order[next].start < blockEnd {
+			j := order[next]
+			next++
+			if j.end <= j.start {
+				errs[j.idx] = fmt.Errorf("span %.2fs-%.2fs is outside the audio", spans[j.idx].Start, spans[j.idx].End)
+				continue
+			}
+			f, err := os.Create(spans[j.idx].Path)
+			if err != nil {
+				errs[j.idx] = err
+				continue
+			}
+			j.out = f
+			if j.w, err = newWAVWriter(f, wr.SampleRate, outChannels); err != nil {
+				finish(j, err)
+				continue
+			}
+			active = append(active, j)
+		}
+
+		remaining := active[:0]
+		for _, j := range active {
+			from := max(j.start, pos) - pos
+			to := min(j.end, blockEnd) - pos
+			if to > from {
+				if err := j.w.WriteFrames(frames[from*int64(outChannels) : to*int64(outChannels)]); err != nil {
+					finish(j, err)
+					continue
+				}
+			}
+			if j.end <= blockEnd {
+				finish(j, nil)
+				continue
+			}
+			remaining = append(remaining, j)
+		}
+		active = remaining
+		pos = blockEnd
+
+		if rerr != nil {
+			if rerr != io.EOF {
+				rerr = fmt.Errorf("decode %s: %w", src, rerr)
+			} else {
+				rerr = nil
+			}
+			// Whatever is still open ran past the end of the data; keep what
+			// was written unless decoding failed.
+			for _, j := range active {
+				finish(j, rerr)
+			}
+			for _, j := range order[next:] {
+				errs[j.idx] = fmt.Errorf("span %.2fs-%.2fs is outside the audio", spans[j.idx].Start, spans[j.idx].End)
+			}
+			break
+		}
+	}
+	return errs, nil
+}
+
+// cutFFmpeg extracts one span with ffmpeg.
+func cutFFmpeg(ctx context.Context, src string, sp cutSpan, out OutputOptions) error {
+	// ffmpeg -i <input> -ss <start> -to <end> [encoding options] <output>
+	// Using -to specifies the absolute end time.
+	args := []string{
+		"-y",
+		"-i", src,
+		"-ss", fmt.Sprintf("%f", sp.Start),
+		"-to", fmt.Sprintf("%f", sp.End),
+	}
+	args = append(args, out.ffmpegArgs()...)
+	args = append(args, sp.Path)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w – %s", err, out)
+	}
+	return nil
+}
+
+func repeatErr(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// downmix averages the channels of interleaved samples into dst.
+func downmix(dst, samples []float32, channels int) []float32 {
+	frames := len(samples) / channels
+	dst = dst[:0]
+	for i := 0; i < frames; i++ {
+		var sum float32
+		for c := 0; c < channels; c++ {
+			sum += samples[i*channels+c]
+		}
+		dst = append(dst, sum/float32(channels))
+	}
+	return dst
+}
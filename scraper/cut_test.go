@@ -0,0 +1,135 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCutWAV cuts a stereo ramp longer than cutFrameBlock and checks that
+// every span holds exactly the source frames between its rounded bounds.
+func TestCutWAV(t *testing.T) {
+	const (
+		rate   = 1000
+		frames = 10000
+	)
+	dir := t.TempDir()
+	samples := make([]float32, 2*frames)
+	for i := 0; i < frames; i++ {
+		samples[2*i] = float32(i%1000) / 2000
+		samples[2*i+1] = -float32(i%700) / 1400
+	}
+	src := filepath.Join(dir, "src.wav")
+	writeTestWAV(t, src, rate, 2, samples)
+	// Compare against the samples as stored, after PCM16 quantization.
+	stored, _, _ := readTestWAV(t, src)
+
+	tests := []struct {
+		name       string
+		spans      []cutSpan
+		mono       bool
+		want       [][2]int // first and end frame of each span
+		wantErrors []bool
+	}{
+		{
+			name:  "whole seconds",
+			spans: []cutSpan{{Start: 1, End: 2.5}},
+			want:  [][2]int{{1000, 2500}},
+		},
+		{
+			name:  "bounds round to the nearest frame",
+			spans: []cutSpan{{Start: 1.0004, End: 2.0006}},
+			want:  [][2]int{{1000, 2001}},
+		},
+		{
+			name:  "across read blocks and overlapping, in any order",
+			spans: []cutSpan{{Start: 5, End: 9}, {Start: 3, End: 6}},
+			want:  [][2]int{{5000, 9000}, {3000, 6000}},
+		},
+		{
+			name:  "clamped at the end of the audio",
+			spans: []cutSpan{{Start: 9.5, End: 12}},
+			want:  [][2]int{{9500, 10000}},
+		},
+		{
+			name:  "downmixed",
+			spans: []cutSpan{{Start: 0, End: 0.5}},
+			mono:  true,
+			want:  [][2]int{{0, 500}},
+		},
+		{
+			name:       "outside the audio",
+			spans:      []cutSpan{{Start: 2, End: 3}, {Start: 11, End: 12}},
+			want:       [][2]int{{2000, 3000}, {}},
+			wantErrors: []bool{false, true},
+		},
+		{
+			name:       "empty",
+			spans:      []cutSpan{{Start: 4, End: 4}},
+			want:       [][2]int{{}},
+			wantErrors: []bool{true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.spans {
+				tt.spans[i].Path = filepath.Join(t.TempDir(), "span.wav")
+			}
+			errs, err := cutWAV(context.Background(), src, tt.spans, OutputOptions{Format: FormatWAV, Mono: tt.mono})
+			if err != nil {
+				t.Fatalf("cutWAV: %v", err)
+			}
+			for i, sp := range tt.spans {
+				wantErr := tt.wantErrors != nil && tt.wantErrors[i]
+				if (errs[i] != nil) != wantErr {
+					t.Errorf("span %d: error %v, want error %v", i, errs[i], wantErr)
+					continue
+				}
+				if wantErr {
+					if _, err := os.Stat(sp.Path); err == nil {
+						t.Errorf("span %d: failed but left %s behind", i, sp.Path)
+					}
+					continue
+				}
+
+				got, gotRate, channels := readTestWAV(t, sp.Path)
+				from, to := tt.want[i][0], tt.want[i][1]
+				want, wantChannels := stored[2*from:2*to], 2
+				if tt.mono {
+					want, wantChannels = downmix(nil, want, 2), 1
+				}
+				if gotRate != rate || channels != wantChannels {
+					t.Errorf("span %d: %d Hz, %d channels", i, gotRate, channels)
+				}
+				if frames := len(got) / channels; frames != to-from {
+					t.Errorf("span %d: %d frames, want %d", i, frames, to-from)
+				}
+				if !reflect.DeepEqual(got, quantize(t, want, channels)) {
+					t.Errorf("span %d: samples are not source frames %d-%d", i, from, to)
+				}
+			}
+		})
+	}
+}
+
+// quantize round trips samples through a PCM16 WAV, as cutWAV's output does.
+func quantize(t *testing.T, samples []float32, channels int) []float32 {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "quantized.wav")
+	writeTestWAV(t, path, 1000, channels, samples)
+	out, _, _ := readTestWAV(t, path)
+	return out
+}
+
+func TestCutWAVResamplingIsUnsupported(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.wav")
+	writeTestWAV(t, src, 1000, 1, make([]float32, 100))
+	_, err := cutWAV(context.Background(), src, []cutSpan{{Start: 0, End: 0.05, Path: filepath.Join(t.TempDir(), "a.wav")}},
+		OutputOptions{Format: FormatWAV, SampleRate: 16000})
+	if !errors.Is(err, errUnsupportedWAV) {
+		t.Errorf("cutWAV error = %v, want errUnsupportedWAV", err)
+	}
+}
@@ -0,0 +1,276 @@
+package scraper
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+)
+
+// decodeSampleRate: Sample rate ffmpeg resamples to when decoding compressed audio for analysis
+const decodeSampleRate = 16000
+
+// monoReader yields the samples of an audio file downmixed to one channel.
+type monoReader interface {
+	// Read fills dst with up to len(dst) samples and returns how many were
+	// read, or io.EOF at the end of the audio.
+	Read(dst []float32) (int, error)
+	SampleRate() int
+	// Clipped is the number of samples read so far in which any of the
+	// source channels reached clipLevel, counted before the downmix so
+	// clipping in a single channel is not averaged away.
+	Clipped() int64
+	Close() error
+}
+
+// openMono opens path for analysis. PCM WAVs are decoded natively at their
+// own sample rate; everything else is decoded by ffmpeg at decodeSampleRate.
+func openMono(ctx context.Context, path string) (monoReader, error) {
+	if FormatFromPath(path) == FormatWAV {
+		if r, err := openWAVMono(path); err == nil {
+			return r, nil
+		}
+	}
+	return openFFmpegMono(ctx, path)
+}
+
+type wavMono struct {
+	f       *os.File
+	wr      *wavReader
+	buf     []float32
+	clipped int64
+}
+
+func openWAVMono(path string) (*wavMono, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	wr, err := newWAVReader(f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wavMono{f: f, wr: wr}, nil
+}
+
+func (m *wavMono) Read(dst []float32) (int, error) {
+	ch := m.wr.Channels
+	if cap(m.buf) < len(dst)*ch {
+		m.buf = make([]float32, len(dst)*ch)
+	}
+	n, err := m.wr.ReadFrames(m.buf[:len(dst)*ch])
+	m.clipped += downmixClipped(dst, m.buf[:n*ch], ch)
+	return n, err
+}
+
+func (m *wavMono) SampleRate() int { return m.wr.SampleRate }
+func (m *wavMono) Clipped() int64  { return m.clipped }
+func (m *wavMono) Close() error    { return m.f.Close() }
+
+// downmixClipped averages the channels of interleaved samples into dst and
+// returns how many of the frames had a channel at or above clipLevel.
+func downmixClipped(dst, samples []float32, channels int) int64 {
+	var clipped int64
+	for i := 0; i < len(samples)/channels; i++ {
+		var (
+			sum  float32
+			clip bool
+		)
+		for _, s := range samples[i*channels : (i+1)*channels] {
+			sum += s
+			clip = clip || max(s, -s) >= clipLevel
+		}
+		dst[i] = sum / float32(channels)
+		if clip {
+			clipped++
+		}
+	}
+	return clipped
+}
+
+type ffmpegMono struct {
+	cmd      *exec.Cmd
+	out      io.ReadCloser
+	r        *bufio.Reader
+	channels int
+	buf      []byte
+	samples  []float32
+	clipped  int64
+}
+
+// openFFmpegMono decodes path with ffmpeg keeping its channels, which are
+// downmixed here so that clipping can be counted per channel.
+func openFFmpegMono(ctx context.Context, path string) (*ffmpegMono, error) {
+	channels := 1
+	if info, err := Probe(ctx, path); err == nil && info.Channels > 0 {
+		channels = info.Channels
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-i", path,
+		"-f", "f32le",
+		"-ac", fmt.Sprint(channels),
+		"-ar", fmt.Sprint(decodeSampleRate),
+		"pipe:1",
+	)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return &ffmpegMono{cmd: cmd, out: out, r: bufio.NewReaderSize(out, 1<<16), channels: channels}, nil
+}
+
+func (m *ffmpegMono) Read(dst []float32) (int, error) {
+	frameBytes := 4 * m.channels
+	need := len(dst) * frameBytes
+	if cap(m.buf) < need {
+		m.buf = make([]byte, need)
+		m.samples = make([]float32, len(dst)*m.channels)
+	}
+	n, err := io.ReadFull(m.r, m.buf[:need])
+	frames := n / frameBytes
+	samples := m.samples[:frames*m.channels]
+	for i := range samples {
+		samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(m.buf[i*4:]))
+	}
+	m.clipped += downmixClipped(dst, samples, m.channels)
+	if err == io.ErrUnexpectedEOF || (err == io.EOF && frames > 0) {
+		err = nil
+	}
+	if err == io.EOF {
+		if werr := m.cmd.Wait(); werr != nil {
+			return 0, fmt.Errorf("ffmpeg: %w", werr)
+		}
+		m.cmd = nil
+	}
+	return frames, err
+}
+
+func (m *ffmpegMono) SampleRate() int { return decodeSampleRate }
+func (m *ffmpegMono) Clipped() int64  { return m.clipped }
+
+func (m *ffmpegMono) Close() error {
+	m.out.Close()
+	if m.cmd != nil {
+		m.cmd.Wait()
+	}
+	return nil
+}
+
+// clipLevel: Sample magnitude at or above which a sample counts as clipped
+const clipLevel = 0.999
+
+// envelope is the RMS level of an audio file in consecutive frames of Hop
+// seconds, along with each frame's peak and number of samples clipped in
+// any channel.
+type envelope struct {
+	Hop float64
+	// Frame is the number of samples in every frame but possibly the last.
+	Frame   int
+	RMS     []float32
+	Peak    []float32
+	Clipped []int32
+}
+
+// rmsEnvelope decodes path and measures its RMS level every hop seconds.
+func rmsEnvelope(ctx context.Context, path string, hop float64) (*envelope, error) {
+	r, err := openMono(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	frame := max(int(hop*float64(r.SampleRate())), 1)
+	env := &envelope{Hop: float64(frame) / float64(r.SampleRate()), Frame: frame}
+	buf := make([]float32, frame)
+	var clippedBefore int64
+	for {
+		n, err := readFullMono(r, buf)
+		if n > 0 {
+			var (
+				sum  float64
+				peak float32
+			)
+			for _, s := range buf[:n] {
+				sum += float64(s) * float64(s)
+				peak = max(peak, s, -s)
+			}
+			env.RMS = append(env.RMS, float32(math.Sqrt(sum/float64(n))))
+			env.Peak = append(env.Peak, peak)
+			env.Clipped = append(env.Clipped, int32(r.Clipped()-clippedBefore))
+			clippedBefore = r.Clipped()
+		}
+		if err == io.EOF {
+			return env, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// quietest returns the time in [from, to] whose frame has the lowest RMS.
+// Ties go to the time closest to prefer.
+func (e *envelope) quietest(from, to, prefer float64) float64 {
+	lo := max(int(from/e.Hop), 0)
+	hi := len(e.RMS) - 1
+	if to/e.Hop < float64(hi) {
+		hi = int(to / e.Hop)
+	}
+	if lo > hi {
+		return prefer
+	}
+	best := -1
+	for i := lo; i <= hi; i++ {
+		if best == -1 || e.RMS[i] < e.RMS[best] ||
+			(e.RMS[i] == e.RMS[best] && math.Abs(e.center(i)-prefer) < math.Abs(e.center(best)-prefer)) {
+			best = i
+		}
+	}
+	return max(min(e.center(best), to), from)
+}
+
+func (e *envelope) center(i int) float64 {
+	return (float64(i) + 0.5) * e.Hop
+}
+
+// frames returns the range of frame indices covering [from, to) seconds.
+func (e *envelope) frames(from, to float64) (lo, hi int) {
+	lo = min(max(int(from/e.Hop), 0), len(e.RMS))
+	hi = min(max(int(math.Ceil(to/e.Hop)), lo), len(e.RMS))
+	return lo, hi
+}
+
+// readFullMono reads until buf is full or the audio ends. Like io.ReadFull it
+// only returns io.EOF when no samples were read at all.
+func readFullMono(r monoReader, buf []float32) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
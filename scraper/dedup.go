@@ -0,0 +1,264 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AudioSimilarity scores how alike two segments sound, from 0 (unrelated) to
+// 1 (identical).
+type AudioSimilarity func(ctx context.Context, a, b *Audio) (float64, error)
+
+// DedupOptions controls Dedup.
+type DedupOptions struct {
+	// MaxPerCluster: Segments kept from each cluster of near-duplicates; 0 disables deduplication
+	MaxPerCluster int `json:"max_per_cluster"`
+	// NGram: Length of the character n-grams transcripts are compared by
+	NGram int `json:"ngram"`
+	// Threshold: Jaccard similarity of the n-gram sets at which two transcripts are duplicates
+	Threshold float64 `json:"threshold"`
+	// CandidateThreshold: Text similarity from which AudioSimilarity is consulted, so that
+	// transcription errors do not hide a duplicate
+	CandidateThreshold float64 `json:"candidate_threshold"`
+	// AudioThreshold: Audio similarity at which a candidate pair is a duplicate
+	AudioThreshold float64 `json:"audio_threshold"`
+	// Fingerprints: Use FingerprintSimilarity when AudioSimilarity is not set
+	Fingerprints bool `json:"fingerprints"`
+
+	// AudioSimilarity, if set, is asked about pairs whose transcripts are
+	// similar but below Threshold.
+	AudioSimilarity AudioSimilarity `json:"-"`
+}
+
+// DefaultDedupOptions returns the comparison settings; deduplication stays
+// off until MaxPerCluster is set.
+func DefaultDedupOptions() DedupOptions {
+	return DedupOptions{
+		NGram:              4,
+		Threshold:          0.8,
+		CandidateThreshold: 0.5,
+		AudioThreshold:     0.7,
+	}
+}
+
+// DedupDrop records a segment Dedup removed.
+type DedupDrop struct {
+	Path    string `json:"path"`
+	Text    string `json:"text"`
+	Cluster int    `json:"cluster"`
+	// KeptAs is the path of the first exemplar kept from the same cluster.
+	KeptAs string `json:"kept_as"`
+}
+
+// DedupReport summarizes a Dedup run.
+type DedupReport struct {
+	Total int `json:"total"`
+	Kept  int `json:"kept"`
+	// Clusters counts groups of two or more near-duplicates.
+	Clusters int         `json:"clusters"`
+	Dropped  []DedupDrop `json:"dropped"`
+}
+
+// Dedup clusters near-duplicate segments and keeps at most
+// opts.MaxPerCluster of each cluster. Two segments are near-duplicates when
+// the character n-grams of their normalized transcripts overlap by at least
+// opts.Threshold, or, with opts.AudioSimilarity set, when they overlap by at
+// least opts.CandidateThreshold and sound alike. Clusters are transitive.
+// Exemplars are picked in input order, preferring segments from videos the
+// cluster has not kept one from yet. The returned segments keep their input
+// order.
+func Dedup(ctx context.Context, items []AudioWithTranscript, opts DedupOptions) ([]AudioWithTranscript, *DedupReport, error) {
+	report := &DedupReport{Total: len(items)}
+	if opts.MaxPerCluster <= 0 {
+		report.Kept = len(items)
+		return items, report, nil
+	}
+	if opts.NGram <= 0 {
+		return nil, nil, fmt.Errorf("invalid dedup n-gram length %d", opts.NGram)
+	}
+
+	grams := make([]map[string]struct{}, len(items))
+	for i, it := range items {
+		grams[i] = textNGrams(dedupText(it), opts.NGram)
+	}
+
+	if opts.AudioSimilarity == nil && opts.Fingerprints {
+		opts.AudioSimilarity = FingerprintSimilarity()
+	}
+	minSim := opts.Threshold
+	if opts.AudioSimilarity != nil {
+		minSim = min(minSim, opts.CandidateThreshold)
+	}
+
+	uf := newUnionFind(len(items))
+	// postings maps each n-gram to the earlier segments containing it, so
+	// that only segments sharing text are ever compared.
+	postings := make(map[string][]int)
+	for i := range items {
+		shared := make(map[int]int)
+		for g := range grams[i] {
+			for _, j := range postings[g] {
+				shared[j]++
+			}
+			postings[g] = append(postings[g], i)
+		}
+		for _, j := range sortedKeys(shared) {
+			if uf.find(i) == uf.find(j) {
+				continue
+			}
+			sim := float64(shared[j]) / float64(len(grams[i])+len(grams[j])-shared[j])
+			if sim < minSim {
+				continue
+			}
+			if sim < opts.Threshold {
+				asim, err := opts.AudioSimilarity(ctx, &items[i].Audio, &items[j].Audio)
+				if err != nil {
+					return nil, nil, fmt.Errorf("compare %s and %s: %w", items[i].Path, items[j].Path, err)
+				}
+				if asim < opts.AudioThreshold {
+					continue
+				}
+			}
+			uf.union(i, j)
+		}
+	}
+
+	clusters := make(map[int][]int)
+	var roots []int
+	for i := range items {
+		r := uf.find(i)
+		if _, ok := clusters[r]; !ok {
+			roots = append(roots, r)
+		}
+		clusters[r] = append(clusters[r], i)
+	}
+
+	keep := make([]bool, len(items))
+	for _, r := range roots {
+		members := clusters[r]
+		if len(members) > 1 {
+			report.Clusters++
+		}
+		kept := pickExemplars(items, members, opts.MaxPerCluster)
+		for _, i := range kept {
+			keep[i] = true
+		}
+		for _, i := range members {
+			if keep[i] {
+				continue
+			}
+			report.Dropped = append(report.Dropped, DedupDrop{
+				Path:    items[i].Path,
+				Text:    strings.TrimSpace(items[i].Text),
+				Cluster: report.Clusters - 1,
+				KeptAs:  items[kept[0]].Path,
+			})
+		}
+	}
+
+	var out []AudioWithTranscript
+	for i, it := range items {
+		if keep[i] {
+			out = append(out, it)
+		}
+	}
+	report.Kept = len(out)
+	return out, report, nil
+}
+
+// WriteDedupReport writes report to path as JSON.
+func WriteDedupReport(path string, report *DedupReport) error {
+	if err := writeJSON(path, report); err != nil {
+		return fmt.Errorf("write dedup report: %w", err)
+	}
+	return nil
+}
+
+// pickExemplars chooses up to n members, first one per distinct source video
+// and then the rest in order. The result is sorted.
+func pickExemplars(items []AudioWithTranscript, members []int, n int) []int {
+	if len(members) <= n {
+		return members
+	}
+	var picked, rest []int
+	seen := make(map[string]bool)
+	for _, i := range members {
+		key, _ := splitGroupKey(items[i], SplitByVideo) // only grouping by channel can fail
+		if !seen[key] && len(picked) < n {
+			seen[key] = true
+			picked = append(picked, i)
+			continue
+		}
+		rest = append(rest, i)
+	}
+	picked = append(picked, rest[:min(n-len(picked), len(rest))]...)
+	sort.Ints(picked)
+	return picked
+}
+
+// dedupText is the text segments are compared by: the normalized transcript
+// when there is one, reduced to lowercase words.
+func dedupText(it AudioWithTranscript) string {
+	text := it.NormalizedText
+	if text == "" {
+		text = it.Text
+	}
+	return strings.Join(textWords(text), " ")
+}
+
+// textNGrams returns the set of n-rune substrings of text. Texts shorter
+// than n yield themselves, so that short segments still compare equal.
+func textNGrams(text string, n int) map[string]struct{} {
+	runes := []rune(text)
+	grams := make(map[string]struct{})
+	if len(runes) == 0 {
+		return grams
+	}
+	if len(runes) <= n {
+		grams[text] = struct{}{}
+		return grams
+	}
+	for i := 0; i+n <= len(runes); i++ {
+		grams[string(runes[i:i+n])] = struct{}{}
+	}
+	return grams
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	uf := make(unionFind, n)
+	for i := range uf {
+		uf[i] = i
+	}
+	return uf
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+// union joins the sets of i and j under the smaller root, so a cluster's
+// root is always its first member.
+func (uf unionFind) union(i, j int) {
+	a, b := uf.find(i), uf.find(j)
+	if a > b {
+		a, b = b, a
+	}
+	uf[b] = a
+}
@@ -0,0 +1,199 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func dedupItem(path, video, text string) AudioWithTranscript {
+	return AudioWithTranscript{Audio: Audio{Path: path, Source: Source{VideoID: video}}, Text: text}
+}
+
+// pairSimilarity is an AudioSimilarity that finds exactly the listed pairs
+// of paths alike.
+func pairSimilarity(pairs ...[2]string) AudioSimilarity {
+	return func(_ context.Context, a, b *Audio) (float64, error) {
+		for _, p := range pairs {
+			if (p[0] == a.Path && p[1] == b.Path) || (p[0] == b.Path && p[1] == a.Path) {
+				return 1, nil
+			}
+		}
+		return 0, nil
+	}
+}
+
+func TestDedup(t *testing.T) {
+	defaults := DefaultDedupOptions()
+	withMax := func(opts DedupOptions, n int) DedupOptions {
+		opts.MaxPerCluster = n
+		return opts
+	}
+
+	tests := []struct {
+		name         string
+		items        []AudioWithTranscript
+		opts         DedupOptions
+		wantKept     []string
+		wantClusters int
+		// wantKeptAs maps each dropped path to the exemplar it was dropped for.
+		wantKeptAs map[string]string
+	}{
+		{
+			name: "disabled",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "twinkle twinkle little star"),
+				dedupItem("b", "v2", "twinkle twinkle little star"),
+			},
+			opts:     defaults,
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "case and punctuation are ignored",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "Twinkle, twinkle, little star!"),
+				dedupItem("b", "v1", "baa baa black sheep"),
+				dedupItem("c", "v1", "twinkle twinkle little star"),
+			},
+			opts:         withMax(defaults, 1),
+			wantKept:     []string{"a", "b"},
+			wantClusters: 1,
+			wantKeptAs:   map[string]string{"c": "a"},
+		},
+		{
+			name: "normalized text is preferred",
+			items: []AudioWithTranscript{
+				{Audio: Audio{Path: "a"}, Text: "1 2 3 4", NormalizedText: "one two three four"},
+				{Audio: Audio{Path: "b"}, Text: "one two three four"},
+			},
+			opts:         withMax(defaults, 1),
+			wantKept:     []string{"a"},
+			wantClusters: 1,
+			wantKeptAs:   map[string]string{"b": "a"},
+		},
+		{
+			name: "small differences stay below the threshold",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "mary had a little lamb"),
+				dedupItem("b", "v2", "mary had a little goat"),
+			},
+			opts:     withMax(defaults, 1),
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name: "exemplars come from distinct videos first",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "row row row your boat"),
+				dedupItem("b", "v1", "row row row your boat"),
+				dedupItem("c", "v2", "row row row your boat"),
+				dedupItem("d", "v3", "row row row your boat"),
+			},
+			opts:         withMax(defaults, 2),
+			wantKept:     []string{"a", "c"},
+			wantClusters: 1,
+			wantKeptAs:   map[string]string{"b": "a", "d": "a"},
+		},
+		{
+			name: "remaining slots are filled in order",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "row row row your boat"),
+				dedupItem("b", "v1", "row row row your boat"),
+				dedupItem("c", "v1", "row row row your boat"),
+			},
+			opts:         withMax(defaults, 2),
+			wantKept:     []string{"a", "b"},
+			wantClusters: 1,
+			wantKeptAs:   map[string]string{"c": "a"},
+		},
+		{
+			name: "similar text that sounds alike is a duplicate",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "jack and jill went up the hill"),
+				dedupItem("b", "v2", "jack and jill went up the mill"),
+				dedupItem("c", "v3", "jack and jill went up the till"),
+			},
+			opts: func() DedupOptions {
+				opts := withMax(defaults, 1)
+				opts.AudioSimilarity = pairSimilarity([2]string{"a", "b"})
+				return opts
+			}(),
+			wantKept:     []string{"a", "c"},
+			wantClusters: 1,
+			wantKeptAs:   map[string]string{"b": "a"},
+		},
+		{
+			name: "clusters are transitive",
+			items: []AudioWithTranscript{
+				dedupItem("a", "v1", "jack and jill went up the hill"),
+				dedupItem("b", "v2", "jack and jill went up the mill"),
+				dedupItem("c", "v3", "jack and jill went up the till"),
+				dedupItem("d", "v4", "humpty dumpty sat on a wall"),
+				dedupItem("e", "v5", "humpty dumpty sat on a wall"),
+			},
+			opts: func() DedupOptions {
+				opts := withMax(defaults, 1)
+				opts.AudioSimilarity = pairSimilarity([2]string{"a", "b"}, [2]string{"b", "c"})
+				return opts
+			}(),
+			wantKept:     []string{"a", "d"},
+			wantClusters: 2,
+			wantKeptAs:   map[string]string{"b": "a", "c": "a", "e": "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, report, err := Dedup(context.Background(), tt.items, tt.opts)
+			if err != nil {
+				t.Fatalf("Dedup: %v", err)
+			}
+			var kept []string
+			for _, it := range out {
+				kept = append(kept, it.Path)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %v, want %v", kept, tt.wantKept)
+			}
+			if report.Total != len(tt.items) || report.Kept != len(out) {
+				t.Errorf("report counts %d of %d kept, want %d of %d", report.Kept, report.Total, len(out), len(tt.items))
+			}
+			if report.Clusters != tt.wantClusters {
+				t.Errorf("report has %d clusters, want %d", report.Clusters, tt.wantClusters)
+			}
+			keptAs := make(map[string]string)
+			for _, d := range report.Dropped {
+				keptAs[d.Path] = d.KeptAs
+			}
+			if len(keptAs) != len(tt.wantKeptAs) || (len(keptAs) > 0 && !reflect.DeepEqual(keptAs, tt.wantKeptAs)) {
+				t.Errorf("dropped %v, want %v", keptAs, tt.wantKeptAs)
+			}
+		})
+	}
+}
+
+func TestDedupErrors(t *testing.T) {
+	items := []AudioWithTranscript{
+		dedupItem("a", "v1", "jack and jill went up the hill"),
+		dedupItem("b", "v2", "jack and jill went up the mill"),
+	}
+	failing := DefaultDedupOptions()
+	failing.MaxPerCluster = 1
+	failing.AudioSimilarity = func(context.Context, *Audio, *Audio) (float64, error) {
+		return 0, errors.New("no audio")
+	}
+	badNGram := DefaultDedupOptions()
+	badNGram.MaxPerCluster, badNGram.NGram = 1, 0
+
+	tests := []struct {
+		name string
+		opts DedupOptions
+	}{
+		{"audio similarity fails", failing},
+		{"invalid n-gram length", badNGram},
+	}
+	for _, tt := range tests {
+		if _, _, err := Dedup(context.Background(), items, tt.opts); err == nil {
+			t.Errorf("%s: Dedup succeeded", tt.name)
+		}
+	}
+}
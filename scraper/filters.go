@@ -0,0 +1,347 @@
+package scraper
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SegmentCandidate is a transcript segment being considered by Segment.
+type SegmentCandidate struct {
+	// Index is the position of the segment in the transcript.
+	Index   int
+	Segment TranscriptSegment
+	// Language is the language whisperx detected for the whole transcript.
+	Language string
+	// Metrics are the segment's signal metrics, or nil if they were not
+	// measured.
+	Metrics *SegmentMetrics
+}
+
+// SegmentFilter decides whether a candidate segment makes it into the
+// dataset. Check returns ok=false together with a human readable reason when
+// the candidate should be dropped.
+type SegmentFilter interface {
+	Name() string
+	Check(c *SegmentCandidate) (reason string, ok bool)
+}
+
+// Rejection records which filter dropped a segment and why.
+type Rejection struct {
+	Index  int     `json:"index"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Text   string  `json:"text"`
+	Filter string  `json:"filter"`
+	Reason string  `json:"reason"`
+}
+
+// SegmentReport summarises a Segment run.
+type SegmentReport struct {
+	Total    int         `json:"total"`
+	Kept     int         `json:"kept"`
+	Rejected []Rejection `json:"rejected"`
+}
+
+// Filters builds the filter chain described by o, in the order they are
+// applied: cheap structural checks first, text checks last.
+func (o SegmentOptions) Filters() []SegmentFilter {
+	filters := []SegmentFilter{
+		DurationFilter{Min: o.MinDurationSeconds, Max: o.MaxDurationSeconds},
+		MinWordsFilter{Min: o.MinWords},
+		ConfidenceRatioFilter{Threshold: o.ConfidenceThreshold, MaxRatio: o.MaxLowConfidenceRatio},
+		ConsecutiveLowConfidenceFilter{Threshold: o.ConfidenceThreshold, MaxRun: o.ConsecutiveLowConfidenceThreshold},
+	}
+	if o.MinCharsPerSecond > 0 || o.MaxCharsPerSecond > 0 {
+		filters = append(filters, CharRateFilter{Min: o.MinCharsPerSecond, Max: o.MaxCharsPerSecond})
+	}
+	if len(o.NonSpeechTokens) > 0 {
+		filters = append(filters, NonSpeechFilter{Tokens: o.NonSpeechTokens})
+	}
+	if len(o.ProfanityWords) > 0 {
+		filters = append(filters, NewProfanityFilter(o.ProfanityWords))
+	}
+	if len(o.Languages) > 0 {
+		filters = append(filters, LanguageFilter{Languages: o.Languages})
+	}
+	if o.MinRMSDBFS != 0 || o.MaxClippingRatio != 0 || o.MinSNRDB != 0 || o.MaxSilenceRatio != 0 || o.MaxResidualMusicDB != 0 {
+		filters = append(filters, SignalFilter{
+			MinRMSDBFS:         o.MinRMSDBFS,
+			MaxClippingRatio:   o.MaxClippingRatio,
+			MinSNRDB:           o.MinSNRDB,
+			MaxSilenceRatio:    o.MaxSilenceRatio,
+			MaxResidualMusicDB: o.MaxResidualMusicDB,
+		})
+	}
+	if o.Speech != nil && o.MinSpeechRatio > 0 {
+		filters = append(filters, SpeechFilter{Speech: o.Speech, MinRatio: o.MinSpeechRatio})
+	}
+	return append(filters, o.ExtraFilters...)
+}
+
+// applyFilters runs c through filters and returns the first rejection, if any.
+func applyFilters(filters []SegmentFilter, c *SegmentCandidate) (*Rejection, bool) {
+	for _, f := range filters {
+		if reason, ok := f.Check(c); !ok {
+			return &Rejection{
+				Index:  c.Index,
+				Start:  c.Segment.Start,
+				End:    c.Segment.End,
+				Text:   c.Segment.Text,
+				Filter: f.Name(),
+				Reason: reason,
+			}, false
+		}
+	}
+	return nil, true
+}
+
+// DurationFilter drops segments with invalid timestamps or whose duration is
+// outside [Min, Max]. A zero Max disables the upper bound.
+type DurationFilter struct {
+	Min float64
+	Max float64
+}
+
+func (DurationFilter) Name() string { return "duration" }
+
+func (f DurationFilter) Check(c *SegmentCandidate) (string, bool) {
+	seg := c.Segment
+	if seg.Start >= seg.End || seg.Start < 0 {
+		return fmt.Sprintf("invalid start/end times (start: %.2fs, end: %.2fs)", seg.Start, seg.End), false
+	}
+	d := seg.End - seg.Start
+	if d < f.Min {
+		return fmt.Sprintf("too short (%.2fs < %.2fs)", d, f.Min), false
+	}
+	if f.Max > 0 && d > f.Max {
+		return fmt.Sprintf("too long (%.2fs > %.2fs)", d, f.Max), false
+	}
+	return "", true
+}
+
+// MinWordsFilter drops segments with fewer than Min words.
+type MinWordsFilter struct {
+	Min int
+}
+
+func (MinWordsFilter) Name() string { return "min_words" }
+
+func (f MinWordsFilter) Check(c *SegmentCandidate) (string, bool) {
+	if n := wordCount(c.Segment); n < f.Min {
+		return fmt.Sprintf("too few words (%d < %d)", n, f.Min), false
+	}
+	return "", true
+}
+
+// ConfidenceRatioFilter drops segments where more than MaxRatio of the
+// aligned words score below Threshold. Unaligned segments pass.
+type ConfidenceRatioFilter struct {
+	Threshold float64
+	MaxRatio  float64
+}
+
+func (ConfidenceRatioFilter) Name() string { return "confidence_ratio" }
+
+func (f ConfidenceRatioFilter) Check(c *SegmentCandidate) (string, bool) {
+	words := c.Segment.Words
+	if len(words) == 0 {
+		return "", true
+	}
+	low := 0
+	for _, w := range words {
+		if w.ConfidenceScore < f.Threshold {
+			low++
+		}
+	}
+	ratio := float64(low) / float64(len(words))
+	if ratio > f.MaxRatio {
+		return fmt.Sprintf(">%.0f%% low confidence words (%.2f%%)", f.MaxRatio*100, ratio*100), false
+	}
+	return "", true
+}
+
+// ConsecutiveLowConfidenceFilter drops segments containing a run of MaxRun or
+// more consecutive words scoring below Threshold. A zero MaxRun disables it.
+type ConsecutiveLowConfidenceFilter struct {
+	Threshold float64
+	MaxRun    int
+}
+
+func (ConsecutiveLowConfidenceFilter) Name() string { return "consecutive_low_confidence" }
+
+func (f ConsecutiveLowConfidenceFilter) Check(c *SegmentCandidate) (string, bool) {
+	if f.MaxRun <= 0 {
+		return "", true
+	}
+	run, longest := 0, 0
+	for _, w := range c.Segment.Words {
+		if w.ConfidenceScore < f.Threshold {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	if longest >= f.MaxRun {
+		return fmt.Sprintf("%d+ consecutive low confidence words (%d found)", f.MaxRun, longest), false
+	}
+	return "", true
+}
+
+// CharRateFilter drops segments whose speaking rate, in non-space characters
+// per second, is outside [Min, Max]. Implausible rates usually mean the
+// transcript and timestamps disagree. A zero bound is not checked.
+type CharRateFilter struct {
+	Min float64
+	Max float64
+}
+
+func (CharRateFilter) Name() string { return "char_rate" }
+
+func (f CharRateFilter) Check(c *SegmentCandidate) (string, bool) {
+	d := c.Segment.End - c.Segment.Start
+	if d <= 0 {
+		return "", true
+	}
+	chars := 0
+	for _, r := range c.Segment.Text {
+		if !unicode.IsSpace(r) {
+			chars++
+		}
+	}
+	rate := float64(chars) / d
+	if f.Min > 0 && rate < f.Min {
+		return fmt.Sprintf("speaking rate too low (%.1f < %.1f chars/s)", rate, f.Min), false
+	}
+	if f.Max > 0 && rate > f.Max {
+		return fmt.Sprintf("speaking rate too high (%.1f > %.1f chars/s)", rate, f.Max), false
+	}
+	return "", true
+}
+
+// NonSpeechFilter drops segments whose text contains any of Tokens, such as
+// "[Music]" or "♪", which whisperx emits for non-speech audio. Matching is
+// case-insensitive.
+type NonSpeechFilter struct {
+	Tokens []string
+}
+
+func (NonSpeechFilter) Name() string { return "non_speech" }
+
+func (f NonSpeechFilter) Check(c *SegmentCandidate) (string, bool) {
+	text := strings.ToLower(c.Segment.Text)
+	for _, tok := range f.Tokens {
+		if tok != "" && strings.Contains(text, strings.ToLower(tok)) {
+			return fmt.Sprintf("contains non-speech token %q", tok), false
+		}
+	}
+	return "", true
+}
+
+// ProfanityFilter drops segments containing any word from its list.
+type ProfanityFilter struct {
+	words map[string]bool
+}
+
+// NewProfanityFilter builds a ProfanityFilter matching words
+// case-insensitively as whole words.
+func NewProfanityFilter(words []string) ProfanityFilter {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[strings.ToLower(strings.TrimSpace(w))] = true
+	}
+	return ProfanityFilter{words: set}
+}
+
+func (ProfanityFilter) Name() string { return "profanity" }
+
+func (f ProfanityFilter) Check(c *SegmentCandidate) (string, bool) {
+	for _, w := range textWords(c.Segment.Text) {
+		if f.words[w] {
+			return fmt.Sprintf("contains listed word %q", w), false
+		}
+	}
+	return "", true
+}
+
+// LanguageFilter drops segments from transcripts whose detected language is
+// not one of Languages (ISO 639-1 codes as reported by whisperx).
+type LanguageFilter struct {
+	Languages []string
+}
+
+func (LanguageFilter) Name() string { return "language" }
+
+func (f LanguageFilter) Check(c *SegmentCandidate) (string, bool) {
+	for _, l := range f.Languages {
+		if strings.EqualFold(l, c.Language) {
+			return "", true
+		}
+	}
+	return fmt.Sprintf("language %q not in %v", c.Language, f.Languages), false
+}
+
+// textWords lower-cases text and splits it into words, dropping punctuation.
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+}
+
+// SignalFilter drops segments whose signal metrics are out of bounds. Zero
+// bounds are not checked, and neither are segments without metrics or, for
+// MaxResidualMusicDB, without a residual music estimate.
+type SignalFilter struct {
+	MinRMSDBFS         float64
+	MaxClippingRatio   float64
+	MinSNRDB           float64
+	MaxSilenceRatio    float64
+	MaxResidualMusicDB float64
+}
+
+func (SignalFilter) Name() string { return "signal" }
+
+func (f SignalFilter) Check(c *SegmentCandidate) (string, bool) {
+	m := c.Metrics
+	if m == nil {
+		return "", true
+	}
+	if f.MinRMSDBFS != 0 && m.RMSDBFS < f.MinRMSDBFS {
+		return fmt.Sprintf("too quiet (%.1f dBFS < %.1f dBFS)", m.RMSDBFS, f.MinRMSDBFS), false
+	}
+	if f.MaxClippingRatio != 0 && m.ClippingRatio > f.MaxClippingRatio {
+		return fmt.Sprintf("clipped (%.2f%% of samples > %.2f%%)", m.ClippingRatio*100, f.MaxClippingRatio*100), false
+	}
+	if f.MinSNRDB != 0 && m.SNRDB < f.MinSNRDB {
+		return fmt.Sprintf("noisy (SNR %.1f dB < %.1f dB)", m.SNRDB, f.MinSNRDB), false
+	}
+	if f.MaxSilenceRatio != 0 && m.SilenceRatio > f.MaxSilenceRatio {
+		return fmt.Sprintf("mostly silence (%.0f%% > %.0f%%)", m.SilenceRatio*100, f.MaxSilenceRatio*100), false
+	}
+	if f.MaxResidualMusicDB != 0 && m.ResidualMusicDB != nil && *m.ResidualMusicDB > f.MaxResidualMusicDB {
+		return fmt.Sprintf("music bleed (%.1f dB > %.1f dB)", *m.ResidualMusicDB, f.MaxResidualMusicDB), false
+	}
+	return "", true
+}
+
+// SpeechFilter drops segments that mostly lie outside the speech regions
+// found by DetectSpeech.
+type SpeechFilter struct {
+	Speech   *SpeechActivity
+	MinRatio float64
+}
+
+func (SpeechFilter) Name() string { return "speech" }
+
+func (f SpeechFilter) Check(c *SegmentCandidate) (string, bool) {
+	seg := c.Segment
+	d := seg.End - seg.Start
+	if d <= 0 {
+		return "", true
+	}
+	if ratio := f.Speech.Overlap(seg.Start, seg.End) / d; ratio < f.MinRatio {
+		return fmt.Sprintf("too little detected speech (%.2f < %.2f)", ratio, f.MinRatio), false
+	}
+	return "", true
+}
@@ -0,0 +1,204 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"sync"
+)
+
+const (
+	// fingerprintFrameSeconds: Approximate length of the analysis window of one sub-fingerprint
+	fingerprintFrameSeconds = 0.37
+	// fingerprintBands: Number of log-spaced bands; adjacent pairs give the 32 bits of a code
+	fingerprintBands = 33
+	// fingerprintMinHz, fingerprintMaxHz: Frequency range the bands cover
+	fingerprintMinHz = 300.0
+	fingerprintMaxHz = 3000.0
+	// fingerprintMaxOffsetSeconds: Largest shift between two recordings Similarity searches for
+	fingerprintMaxOffsetSeconds = 30.0
+)
+
+// Fingerprint is a compact acoustic summary of a recording in the style of
+// chromaprint: one 32-bit code per frame, each bit telling whether the energy
+// difference between two adjacent frequency bands grew or shrank since the
+// previous frame. Re-encodes, volume changes and small offsets leave most
+// bits intact.
+type Fingerprint struct {
+	// Rate is the number of codes per second of audio.
+	Rate  float64  `json:"rate"`
+	Codes []uint32 `json:"codes"`
+}
+
+// Duration is the length of audio the fingerprint covers, in seconds.
+func (f *Fingerprint) Duration() float64 {
+	if f.Rate == 0 {
+		return 0
+	}
+	return float64(len(f.Codes)) / f.Rate
+}
+
+// ComputeFingerprint decodes the audio at path and fingerprints it.
+func ComputeFingerprint(ctx context.Context, path string) (*Fingerprint, error) {
+	r, err := openMono(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	defer r.Close()
+
+	rate := r.SampleRate()
+	frame := 1 << int(math.Round(math.Log2(fingerprintFrameSeconds*float64(rate))))
+	hop := frame / 32
+
+	window := make([]float64, frame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frame-1))
+	}
+	edges := make([]int, fingerprintBands+1)
+	for b := range edges {
+		hz := fingerprintMinHz * math.Pow(fingerprintMaxHz/fingerprintMinHz, float64(b)/fingerprintBands)
+		edges[b] = min(int(hz*float64(frame)/float64(rate)), frame/2)
+	}
+
+	fp := &Fingerprint{Rate: float64(rate) / float64(hop)}
+	var (
+		samples  = make([]float32, frame)
+		spectrum = make([]complex128, frame)
+		prev     []float64
+		filled   int
+	)
+	for {
+		n, err := readFullMono(r, samples[filled:])
+		filled += n
+		if filled == frame {
+			for i, s := range samples {
+				spectrum[i] = complex(float64(s)*window[i], 0)
+			}
+			fft(spectrum)
+			energies := make([]float64, fingerprintBands)
+			for b := range energies {
+				for k := edges[b]; k < max(edges[b+1], edges[b]+1); k++ {
+					energies[b] += real(spectrum[k])*real(spectrum[k]) + imag(spectrum[k])*imag(spectrum[k])
+				}
+			}
+			if prev != nil {
+				fp.Codes = append(fp.Codes, fingerprintCode(prev, energies))
+			}
+			prev = energies
+			filled = copy(samples, samples[hop:])
+		}
+		if err == io.EOF {
+			return fp, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+	}
+}
+
+func fingerprintCode(prev, cur []float64) uint32 {
+	var code uint32
+	for m := 0; m < fingerprintBands-1; m++ {
+		if (cur[m]-cur[m+1])-(prev[m]-prev[m+1]) > 0 {
+			code |= 1 << m
+		}
+	}
+	return code
+}
+
+// Similarity compares two fingerprints taken at the same rate. It slides
+// them against each other by up to fingerprintMaxOffsetSeconds and returns
+// the best fraction of matching bits over an overlap covering at least half
+// of the shorter one: about 0.5 for unrelated audio and close to 1 for the
+// same recording.
+func (f *Fingerprint) Similarity(o *Fingerprint) float64 {
+	if f.Rate == 0 || math.Abs(f.Rate-o.Rate) > 1e-6 || len(f.Codes) == 0 || len(o.Codes) == 0 {
+		return 0
+	}
+	minOverlap := max(min(len(f.Codes), len(o.Codes))/2, 1)
+	maxOffset := int(fingerprintMaxOffsetSeconds * f.Rate)
+
+	best := 0.0
+	for off := -maxOffset; off <= maxOffset; off++ {
+		// Code i of f lines up with code i+off of o.
+		from := max(0, -off)
+		to := min(len(f.Codes), len(o.Codes)-off)
+		if to-from < minOverlap {
+			continue
+		}
+		diff := 0
+		for i := from; i < to; i++ {
+			diff += bits.OnesCount32(f.Codes[i] ^ o.Codes[i+off])
+		}
+		if sim := 1 - float64(diff)/float64(32*(to-from)); sim > best {
+			best = sim
+		}
+	}
+	return best
+}
+
+// FingerprintSimilarity is an AudioSimilarity for Dedup that compares
+// fingerprints of the segment files, computing each one once.
+func FingerprintSimilarity() AudioSimilarity {
+	var (
+		mu    sync.Mutex
+		cache = make(map[string]*Fingerprint)
+	)
+	get := func(ctx context.Context, path string) (*Fingerprint, error) {
+		mu.Lock()
+		fp, ok := cache[path]
+		mu.Unlock()
+		if ok {
+			return fp, nil
+		}
+		fp, err := ComputeFingerprint(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		cache[path] = fp
+		mu.Unlock()
+		return fp, nil
+	}
+	return func(ctx context.Context, a, b *Audio) (float64, error) {
+		fa, err := get(ctx, a.Path)
+		if err != nil {
+			return 0, err
+		}
+		fb, err := get(ctx, b.Path)
+		if err != nil {
+			return 0, err
+		}
+		return fa.Similarity(fb), nil
+	}
+}
+
+// fft computes the discrete Fourier transform of x in place. len(x) must be
+// a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package scraper
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// melody makes seconds of a tune at rate: a random note between 300 Hz and
+// 3 kHz every quarter second over a little noise, so that consecutive
+// fingerprint frames differ as they do in music.
+func melody(seed int64, rate int, seconds float64) []float32 {
+	rng := rand.New(rand.NewSource(seed))
+	out := make([]float32, int(seconds*float64(rate)))
+	var hz, phase float64
+	for i := range out {
+		if i%(rate/4) == 0 {
+			hz = 300 * math.Pow(10, rng.Float64())
+		}
+		phase += 2 * math.Pi * hz / float64(rate)
+		out[i] = float32(0.5*math.Sin(phase) + 0.05*rng.NormFloat64())
+	}
+	return out
+}
+
+func testFingerprint(t *testing.T, rate int, samples []float32) *Fingerprint {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audio.wav")
+	writeTestWAV(t, path, rate, 1, samples)
+	fp, err := ComputeFingerprint(context.Background(), path)
+	if err != nil {
+		t.Fatalf("ComputeFingerprint: %v", err)
+	}
+	return fp
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	const rate = 8000
+	a := melody(1, rate, 20)
+	quieter := make([]float32, len(a))
+	for i, s := range a {
+		quieter[i] = s / 4
+	}
+	fpA := testFingerprint(t, rate, a)
+
+	tests := []struct {
+		name     string
+		b        []float32
+		min, max float64
+	}{
+		{name: "identical", b: a, min: 0.99, max: 1},
+		{name: "quieter", b: quieter, min: 0.99, max: 1},
+		{name: "starting 3s later", b: a[3*rate:], min: 0.9, max: 1},
+		{name: "starting off the hop grid", b: a[3*rate+80:], min: 0.9, max: 1},
+		{name: "with 2s of silence in front", b: append(make([]float32, 2*rate+37), a[:15*rate]...), min: 0.9, max: 1},
+		{name: "unrelated", b: melody(2, rate, 20), min: 0.4, max: 0.6},
+		{name: "unrelated and shorter", b: melody(3, rate, 5), min: 0.4, max: 0.6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fpB := testFingerprint(t, rate, tt.b)
+			if got := fpA.Similarity(fpB); got < tt.min || got > tt.max {
+				t.Errorf("Similarity = %.3f, want %.2f to %.2f", got, tt.min, tt.max)
+			}
+			if ab, ba := fpA.Similarity(fpB), fpB.Similarity(fpA); ab != ba {
+				t.Errorf("Similarity is not symmetric: %.3f and %.3f", ab, ba)
+			}
+		})
+	}
+}
+
+func TestFingerprintSimilarityNeedsTheSameRate(t *testing.T) {
+	fp := &Fingerprint{Rate: 125, Codes: []uint32{1, 2, 3}}
+	tests := []struct {
+		name string
+		o    *Fingerprint
+	}{
+		{"other rate", &Fingerprint{Rate: 250, Codes: []uint32{1, 2, 3}}},
+		{"empty", &Fingerprint{Rate: 125}},
+	}
+	for _, tt := range tests {
+		if got := fp.Similarity(tt.o); got != 0 {
+			t.Errorf("%s: Similarity = %v, want 0", tt.name, got)
+		}
+	}
+}
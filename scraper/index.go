@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IndexEntry is one downloaded source recorded in a RunIndex.
+type IndexEntry struct {
+	URL string `json:"url"`
+	// Clip is the part of URL that was downloaded; clips of one URL are
+	// separate entries.
+	Clip        Clip         `json:"clip"`
+	VideoID     string       `json:"video_id,omitempty"`
+	Duration    float64      `json:"duration"`
+	Fingerprint *Fingerprint `json:"fingerprint"`
+	// DuplicateOf is the URL of the earlier source this one is a re-upload
+	// of; such sources are not processed.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Similarity is the fingerprint similarity to DuplicateOf.
+	Similarity float64   `json:"similarity,omitempty"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+// RunIndex remembers the sources processed by earlier runs so that
+// re-uploads of the same recording under another URL can be skipped.
+type RunIndex struct {
+	path    string
+	Entries []IndexEntry `json:"entries"`
+}
+
+// OpenRunIndex loads the index at path. A missing file yields an empty index
+// that Save will create.
+func OpenRunIndex(path string) (*RunIndex, error) {
+	ix := &RunIndex{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read run index: %w", err)
+	}
+	if err := json.Unmarshal(data, ix); err != nil {
+		return nil, fmt.Errorf("parse run index %s: %w", path, err)
+	}
+	return ix, nil
+}
+
+// Lookup returns the first processed entry from another URL whose
+// fingerprint is at least threshold similar to fp, and the similarity.
+// Clips of url itself are never reported, even where they overlap.
+func (ix *RunIndex) Lookup(url string, fp *Fingerprint, threshold float64) (*IndexEntry, float64) {
+	for i := range ix.Entries {
+		e := &ix.Entries[i]
+		if e.URL == url || e.DuplicateOf != "" || e.Fingerprint == nil {
+			continue
+		}
+		if sim := fp.Similarity(e.Fingerprint); sim >= threshold {
+			return e, sim
+		}
+	}
+	return nil, 0
+}
+
+// Add records e, replacing an earlier entry for the same URL and clip.
+func (ix *RunIndex) Add(e IndexEntry) {
+	for i := range ix.Entries {
+		if ix.Entries[i].URL == e.URL && ix.Entries[i].Clip == e.Clip {
+			ix.Entries[i] = e
+			return
+		}
+	}
+	ix.Entries = append(ix.Entries, e)
+}
+
+// Save writes the index back to the file it was opened from, atomically.
+func (ix *RunIndex) Save() error {
+	if err := os.MkdirAll(filepath.Dir(ix.path), 0755); err != nil {
+		return fmt.Errorf("mkdir run index dir: %w", err)
+	}
+	tmp := ix.path + ".tmp"
+	if err := writeJSON(tmp, ix); err != nil {
+		return fmt.Errorf("write run index: %w", err)
+	}
+	if err := os.Rename(tmp, ix.path); err != nil {
+		return fmt.Errorf("write run index: %w", err)
+	}
+	return nil
+}
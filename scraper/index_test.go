@@ -0,0 +1,73 @@
+package scraper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunIndexLookup(t *testing.T) {
+	const rate = 8000
+	song := testFingerprint(t, rate, melody(1, rate, 20))
+	other := testFingerprint(t, rate, melody(2, rate, 20))
+	ix := &RunIndex{Entries: []IndexEntry{
+		{URL: "https://youtu.be/song", Fingerprint: song},
+		{URL: "https://youtu.be/other", Fingerprint: other},
+		{URL: "https://youtu.be/skipped", Fingerprint: song, DuplicateOf: "https://youtu.be/song"},
+	}}
+	reupload := testFingerprint(t, rate, melody(1, rate, 20)[2*rate:])
+
+	tests := []struct {
+		name    string
+		url     string
+		fp      *Fingerprint
+		wantURL string
+	}{
+		{name: "re-upload", url: "https://youtu.be/copy", fp: reupload, wantURL: "https://youtu.be/song"},
+		{name: "the same URL again", url: "https://youtu.be/song", fp: song},
+		{name: "new audio", url: "https://youtu.be/new", fp: testFingerprint(t, rate, melody(3, rate, 20))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, sim := ix.Lookup(tt.url, tt.fp, 0.8)
+			var got string
+			if e != nil {
+				got = e.URL
+			}
+			if got != tt.wantURL {
+				t.Errorf("Lookup = %q (similarity %.2f), want %q", got, sim, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestRunIndexAdd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	ix, err := OpenRunIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const url = "https://youtu.be/song"
+	first := Clip{End: time.Minute}
+	second := Clip{Start: time.Minute, End: 2 * time.Minute}
+	ix.Add(IndexEntry{URL: url, Clip: first, Duration: 1})
+	ix.Add(IndexEntry{URL: url, Clip: second, Duration: 2})
+	ix.Add(IndexEntry{URL: url, Clip: first, Duration: 3})
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err = OpenRunIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IndexEntry{{URL: url, Clip: first, Duration: 3}, {URL: url, Clip: second, Duration: 2}}
+	if len(ix.Entries) != len(want) {
+		t.Fatalf("index has %d entries, want %d", len(ix.Entries), len(want))
+	}
+	for i, e := range ix.Entries {
+		if e.URL != want[i].URL || e.Clip != want[i].Clip || e.Duration != want[i].Duration {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+}
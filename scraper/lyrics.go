@@ -0,0 +1,252 @@
+package scraper
+
+import (
+	"strings"
+)
+
+// SegmentMode selects how Segment turns a transcript into utterances.
+type SegmentMode string
+
+const (
+	// SegmentModeASR cuts along whisperx's own segments (optionally resegmented).
+	SegmentModeASR SegmentMode = "asr"
+	// SegmentModeLyrics cuts along lyric lines and annotates rhymes.
+	SegmentModeLyrics SegmentMode = "lyrics"
+)
+
+// LyricOptions controls how a transcript is split into lyric lines.
+type LyricOptions struct {
+	// LinePauseSeconds: A pause at least this long between two words ends a line
+	LinePauseSeconds float64 `json:"line_pause_seconds"`
+	// StanzaPauseSeconds: A pause at least this long also starts a new stanza
+	StanzaPauseSeconds float64 `json:"stanza_pause_seconds"`
+	// MinLineWords: Punctuation only ends a line once it has at least this many words
+	MinLineWords int `json:"min_line_words"`
+	// MaxLineSeconds: Lines are force-broken at the next word once they reach this length
+	MaxLineSeconds float64 `json:"max_line_seconds"`
+}
+
+// DefaultLyricOptions suits nursery rhymes: short lines with clear pauses.
+func DefaultLyricOptions() LyricOptions {
+	return LyricOptions{
+		LinePauseSeconds:   0.35,
+		StanzaPauseSeconds: 1.5,
+		MinLineWords:       3,
+		MaxLineSeconds:     8,
+	}
+}
+
+// LyricInfo places a segment within the song it was cut from.
+type LyricInfo struct {
+	// Line and Stanza are indices over the whole transcript.
+	Line   int `json:"line"`
+	Stanza int `json:"stanza"`
+	// RhymeKey is the phonetic ending of the line's last word.
+	RhymeKey string `json:"rhyme_key"`
+	// Scheme is the line's rhyme letter within its stanza, e.g. "A" and "A"
+	// for a rhyming pair followed by "B" for the next, unrelated line.
+	Scheme string `json:"scheme"`
+	// RhymesWithPrevious is set when the line's ending rhymes with the line
+	// before it in the same stanza.
+	RhymesWithPrevious bool `json:"rhymes_with_previous"`
+	// Couplet numbers rhyming pairs of consecutive lines across the
+	// transcript; -1 when the line is not part of one.
+	Couplet int `json:"couplet"`
+}
+
+// LyricLines splits tat into lyric lines using word timings, pauses and
+// punctuation, and annotates each line with stanza and rhyme information.
+// The returned infos are parallel to the segments of the returned
+// transcript. Segments without word timings are kept as single lines.
+func LyricLines(tat *TimeAlignedTranscript, opts LyricOptions) (*TimeAlignedTranscript, []LyricInfo) {
+	out := &TimeAlignedTranscript{Language: tat.Language}
+	var infos []LyricInfo
+
+	stanza := 0
+	var line []resegmentWord
+	emit := func() {
+		if len(line) == 0 {
+			return
+		}
+		out.Segments = append(out.Segments, makeSegment(line))
+		infos = append(infos, LyricInfo{Line: len(infos), Stanza: stanza})
+		line = nil
+	}
+	// breakStanza makes the next line start a new stanza.
+	breakStanza := func() {
+		if len(infos) > 0 && infos[len(infos)-1].Stanza == stanza {
+			stanza++
+		}
+	}
+
+	// prevEnd is the end of the previous timed word, or -1 at the start of
+	// the transcript and after unaligned segments.
+	prevEnd := -1.0
+	for _, seg := range tat.Segments {
+		if len(seg.Words) == 0 {
+			emit()
+			breakStanza()
+			out.Segments = append(out.Segments, seg)
+			infos = append(infos, LyricInfo{Line: len(infos), Stanza: stanza})
+			breakStanza()
+			prevEnd = -1
+			continue
+		}
+
+		for _, w := range seg.Words {
+			if w.End <= w.Start {
+				pin := seg.Start
+				if prevEnd >= 0 {
+					pin = prevEnd
+				}
+				w.Start, w.End = pin, pin
+			}
+			if prevEnd >= 0 {
+				gap := w.Start - prevEnd
+				if gap >= opts.LinePauseSeconds {
+					emit()
+				}
+				if gap >= opts.StanzaPauseSeconds {
+					emit()
+					breakStanza()
+				}
+			}
+			if len(line) > 0 && opts.MaxLineSeconds > 0 && w.End-line[0].Start > opts.MaxLineSeconds {
+				emit()
+			}
+
+			line = append(line, resegmentWord{TimeAlignedWord: w})
+			prevEnd = w.End
+			if len(line) >= opts.MinLineWords && endsClause(w.Word) {
+				emit()
+			}
+		}
+	}
+	emit()
+
+	annotateRhymes(out.Segments, infos)
+	return out, infos
+}
+
+// annotateRhymes fills in the rhyme fields of infos.
+func annotateRhymes(segs []TranscriptSegment, infos []LyricInfo) {
+	couplet := 0
+	type scheme struct {
+		key    string
+		letter string
+	}
+	var (
+		schemes []scheme
+		next    byte
+	)
+	for i := range infos {
+		words := textWords(segs[i].Text)
+		if len(words) > 0 {
+			infos[i].RhymeKey = rhymeKey(words[len(words)-1])
+		}
+		infos[i].Couplet = -1
+
+		if i == 0 || infos[i].Stanza != infos[i-1].Stanza {
+			schemes = schemes[:0]
+			next = 'A'
+		}
+
+		if i > 0 && infos[i].Stanza == infos[i-1].Stanza && rhymes(infos[i-1].RhymeKey, infos[i].RhymeKey) {
+			infos[i].RhymesWithPrevious = true
+			// Pair lines up AABB style: a line already closing a couplet
+			// does not also open the next one.
+			if infos[i-1].Couplet == -1 {
+				infos[i-1].Couplet = couplet
+				infos[i].Couplet = couplet
+				couplet++
+			}
+		}
+
+		key := infos[i].RhymeKey
+		for _, sc := range schemes {
+			if rhymes(sc.key, key) {
+				infos[i].Scheme = sc.letter
+				break
+			}
+		}
+		if infos[i].Scheme == "" {
+			infos[i].Scheme = string(next)
+			if next < 'Z' {
+				next++
+			}
+			if key != "" {
+				schemes = append(schemes, scheme{key: key, letter: infos[i].Scheme})
+			}
+		}
+	}
+}
+
+// rhymes reports whether two rhyme keys match. Keys match if they are equal
+// or share a vowel-initial ending of at least two letters.
+func rhymes(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n >= 2 && strings.ContainsAny(a[len(a)-n:], "aeiouy")
+}
+
+// rhymeKey approximates the rhyming sound of an English word: its last vowel
+// group and everything after it, after folding a few spellings that sound
+// alike ("night"/"bite", "sky"/"high", "back"/"lack").
+func rhymeKey(word string) string {
+	w := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(word))
+	if w == "" {
+		return ""
+	}
+
+	for _, r := range []struct{ from, to string }{
+		{"igh", "i"}, {"ck", "k"}, {"ph", "f"}, {"ee", "i"}, {"ea", "i"},
+	} {
+		w = strings.ReplaceAll(w, r.from, r.to)
+	}
+	// Silent trailing e: "bite" -> "bit", "are" -> "ar".
+	if len(w) > 2 && w[len(w)-1] == 'e' && !isVowel(w[len(w)-2]) {
+		w = w[:len(w)-1]
+	}
+	// Word-final y after a consonant sounds like i: "sky", "my".
+	if len(w) > 1 && w[len(w)-1] == 'y' && !isVowel(w[len(w)-2]) {
+		w = w[:len(w)-1] + "i"
+	}
+
+	end := len(w)
+	for end > 0 && !isVowel(w[end-1]) {
+		end--
+	}
+	if end == 0 {
+		return w
+	}
+	start := end
+	for start > 0 && isVowel(w[start-1]) {
+		start--
+	}
+	return w[start:]
+}
+
+func isVowel(c byte) bool {
+	return strings.IndexByte("aeiou", c) >= 0
+}
+
+// endsClause reports whether word ends with punctuation that usually closes
+// a lyric line.
+func endsClause(word string) bool {
+	w := strings.TrimSpace(word)
+	return strings.HasSuffix(w, ".") || strings.HasSuffix(w, ",") || strings.HasSuffix(w, "!") ||
+		strings.HasSuffix(w, "?") || strings.HasSuffix(w, ";") || strings.HasSuffix(w, ":")
+}
@@ -0,0 +1,147 @@
+package scraper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sung makes one whisperx segment of lines sung 0.35 s a word, with a 0.5 s
+// pause after each line, or 2 s before lines starting with "/".
+func sung(lines ...string) TranscriptSegment {
+	var seg TranscriptSegment
+	t := 0.0
+	for i, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "/"); ok {
+			line = rest
+			t += 1.5
+		}
+		for _, w := range strings.Fields(line) {
+			seg.Words = append(seg.Words, TimeAlignedWord{Start: t, End: t + 0.3, Word: w})
+			t += 0.35
+		}
+		if i < len(lines)-1 {
+			t += 0.5
+		}
+	}
+	seg.Start, seg.End = seg.Words[0].Start, seg.Words[len(seg.Words)-1].End
+	return seg
+}
+
+func TestLyricLines(t *testing.T) {
+	type line struct {
+		text     string
+		stanza   int
+		scheme   string
+		previous bool
+		couplet  int
+	}
+	tests := []struct {
+		name string
+		segs []TranscriptSegment
+		opts LyricOptions
+		want []line
+	}{
+		{
+			name: "couplets and stanzas",
+			segs: []TranscriptSegment{sung(
+				"Twinkle twinkle little star", "How I wonder what you are",
+				"Up above the world so high", "Like a diamond in the sky",
+				"/When the blazing sun is gone", "When he nothing shines upon",
+			)},
+			opts: DefaultLyricOptions(),
+			want: []line{
+				{"Twinkle twinkle little star", 0, "A", false, 0},
+				{"How I wonder what you are", 0, "A", true, 0},
+				{"Up above the world so high", 0, "B", false, 1},
+				{"Like a diamond in the sky", 0, "B", true, 1},
+				{"When the blazing sun is gone", 1, "A", false, 2},
+				{"When he nothing shines upon", 1, "A", true, 2},
+			},
+		},
+		{
+			name: "a third rhyming line does not open another couplet",
+			segs: []TranscriptSegment{sung("I saw a cat", "It wore a hat", "It hit a bat", "And then a dog")},
+			opts: DefaultLyricOptions(),
+			want: []line{
+				{"I saw a cat", 0, "A", false, 0},
+				{"It wore a hat", 0, "A", true, 0},
+				{"It hit a bat", 0, "A", true, -1},
+				{"And then a dog", 0, "B", false, -1},
+			},
+		},
+		{
+			name: "punctuation ends lines once they are long enough",
+			segs: []TranscriptSegment{sung("Yes, sir, yes sir, three bags full.")},
+			opts: DefaultLyricOptions(),
+			want: []line{
+				{"Yes, sir, yes sir,", 0, "A", false, -1},
+				{"three bags full.", 0, "B", false, -1},
+			},
+		},
+		{
+			name: "long lines are force broken",
+			segs: []TranscriptSegment{sung("one two three four five six seven eight")},
+			opts: LyricOptions{LinePauseSeconds: 0.35, StanzaPauseSeconds: 1.5, MinLineWords: 3, MaxLineSeconds: 1},
+			want: []line{
+				{"one two three", 0, "A", false, -1},
+				{"four five six", 0, "B", false, -1},
+				{"seven eight", 0, "C", false, -1},
+			},
+		},
+		{
+			name: "unaligned segments are lines of their own stanza",
+			segs: []TranscriptSegment{
+				sung("Hickory dickory dock"),
+				{Text: "♪", Start: 2, End: 3},
+				sung("The mouse ran up the clock"),
+			},
+			opts: DefaultLyricOptions(),
+			want: []line{
+				{"Hickory dickory dock", 0, "A", false, -1},
+				{"♪", 1, "A", false, -1},
+				{"The mouse ran up the clock", 2, "A", false, -1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, infos := LyricLines(&TimeAlignedTranscript{Segments: tt.segs, Language: "en"}, tt.opts)
+			if len(infos) != len(out.Segments) {
+				t.Fatalf("%d infos for %d lines", len(infos), len(out.Segments))
+			}
+			var got []line
+			for i, seg := range out.Segments {
+				if infos[i].Line != i {
+					t.Errorf("line %d is numbered %d", i, infos[i].Line)
+				}
+				got = append(got, line{seg.Text, infos[i].Stanza, infos[i].Scheme, infos[i].RhymesWithPrevious, infos[i].Couplet})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LyricLines =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRhymeKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"star", "are", true},
+		{"night", "bite", true},
+		{"sky", "high", true},
+		{"back", "lack", true},
+		{"gone", "upon", true},
+		{"Dock!", "clock", true},
+		{"star", "sky", false},
+		{"cat", "dog", false},
+		{"", "dog", false},
+	}
+	for _, tt := range tests {
+		if got := rhymes(rhymeKey(tt.a), rhymeKey(tt.b)); got != tt.want {
+			t.Errorf("%q and %q rhyme: %v, want %v (keys %q, %q)", tt.a, tt.b, got, tt.want, rhymeKey(tt.a), rhymeKey(tt.b))
+		}
+	}
+}
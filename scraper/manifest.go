@@ -0,0 +1,256 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestFormat is a dataset manifest layout understood by common speech
+// toolkits.
+type ManifestFormat string
+
+const (
+	// ManifestJSONL writes one JSON object per segment, NeMo/Coqui style.
+	ManifestJSONL ManifestFormat = "jsonl"
+	// ManifestLJSpeech writes LJSpeech's pipe separated id|text|normalized.
+	ManifestLJSpeech ManifestFormat = "ljspeech"
+	// ManifestAudioFolder writes the metadata.csv of a Hugging Face
+	// audiofolder dataset.
+	ManifestAudioFolder ManifestFormat = "audiofolder"
+)
+
+// FileName is the conventional name of a manifest in this format.
+func (f ManifestFormat) FileName() string {
+	switch f {
+	case ManifestJSONL:
+		return "manifest.jsonl"
+	case ManifestLJSpeech:
+		return "ljspeech.csv"
+	case ManifestAudioFolder:
+		return "metadata.csv"
+	}
+	return string(f)
+}
+
+// manifestEntry is one line of a JSONL manifest. Times are in seconds.
+type manifestEntry struct {
+	AudioFilepath         string          `json:"audio_filepath"`
+	AccompanimentFilepath string          `json:"accompaniment_filepath,omitempty"`
+	Duration              float64         `json:"duration"`
+	Text                  string          `json:"text"`
+	NormalizedText        string          `json:"normalized_text,omitempty"`
+	SourceURL             string          `json:"source_url,omitempty"`
+	SourceOffset          float64         `json:"source_offset"`
+	SourceEnd             float64         `json:"source_end"`
+	Chapter               string          `json:"chapter,omitempty"`
+	Metrics               *SegmentMetrics `json:"metrics,omitempty"`
+}
+
+// WriteManifests writes a manifest for items in every format to dir, using
+// each format's FileName.
+func WriteManifests(dir string, formats []ManifestFormat, items []AudioWithTranscript) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("mkdir manifest dir: %w", err)
+	}
+	for _, f := range formats {
+		if err := WriteManifest(filepath.Join(dir, f.FileName()), f, items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SegmentListFile is the name of the list of segments Collect looks for in
+// each source's directory.
+const SegmentListFile = "segments.json"
+
+// WriteSegmentList stores the segments produced from one source at path, so
+// that later runs can rebuild the dataset manifests from every source.
+func WriteSegmentList(path string, items []AudioWithTranscript) error {
+	if items == nil {
+		items = []AudioWithTranscript{}
+	}
+	if err := writeJSON(path, items); err != nil {
+		return fmt.Errorf("write segment list: %w", err)
+	}
+	return nil
+}
+
+// CollectSegments reads the SegmentListFile of every directory directly
+// below root, in name order, and returns all their segments: the dataset
+// built up by every run so far.
+func CollectSegments(root string) ([]AudioWithTranscript, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "*", SegmentListFile))
+	if err != nil {
+		return nil, fmt.Errorf("find segment lists: %w", err)
+	}
+	sort.Strings(paths)
+	var all []AudioWithTranscript
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read segment list: %w", err)
+		}
+		var items []AudioWithTranscript
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("unmarshal segment list %s: %w", path, err)
+		}
+		all = append(all, items...)
+	}
+	return all, nil
+}
+
+// WriteManifest writes items to path in the given format. Audio paths are
+// written as they are for JSONL; audiofolder file names are relative to the
+// manifest's directory, as those toolkits expect. LJSpeech loaders look for
+// wavs/<id>.wav next to the manifest, so every segment is linked there under
+// an id derived from its path relative to the manifest's directory, which
+// keeps ids unique across sources. LJSpeech's fixed three columns have no
+// room for durations or source offsets.
+func WriteManifest(path string, format ManifestFormat, items []AudioWithTranscript) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	switch format {
+	case ManifestJSONL:
+		err = writeJSONLManifest(w, items)
+	case ManifestLJSpeech:
+		err = writeLJSpeechManifest(w, filepath.Dir(path), items)
+	case ManifestAudioFolder:
+		err = writeAudioFolderManifest(w, filepath.Dir(path), items)
+	default:
+		err = fmt.Errorf("unknown manifest format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("write %s manifest: %w", format, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write %s manifest: %w", format, err)
+	}
+	return f.Close()
+}
+
+func writeJSONLManifest(w *bufio.Writer, items []AudioWithTranscript) error {
+	enc := json.NewEncoder(w)
+	for _, it := range items {
+		offset := it.Source.Offset.Seconds()
+		var accompaniment string
+		if it.Accompaniment != nil {
+			accompaniment = it.Accompaniment.Path
+		}
+		if err := enc.Encode(manifestEntry{
+			AudioFilepath:         it.Path,
+			AccompanimentFilepath: accompaniment,
+			Duration:              it.Duration.Seconds(),
+			Text:                  strings.TrimSpace(it.Text),
+			NormalizedText:        it.NormalizedText,
+			SourceURL:             it.Source.URL,
+			SourceOffset:          offset,
+			SourceEnd:             offset + it.Duration.Seconds(),
+			Chapter:               it.Source.Chapter,
+			Metrics:               it.Metrics,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLJSpeechManifest(w *bufio.Writer, dir string, items []AudioWithTranscript) error {
+	wavs := filepath.Join(dir, "wavs")
+	if err := os.MkdirAll(wavs, 0755); err != nil {
+		return fmt.Errorf("mkdir wavs: %w", err)
+	}
+	for _, it := range items {
+		id, err := ljspeechID(dir, it)
+		if err != nil {
+			return err
+		}
+		if err := linkFile(it.Path, filepath.Join(wavs, id+".wav")); err != nil {
+			return fmt.Errorf("link %s: %w", id, err)
+		}
+		text := oneLine(it.Text)
+		normalized := oneLine(it.NormalizedText)
+		if normalized == "" {
+			normalized = text
+		}
+		if _, err := fmt.Fprintf(w, "%s|%s|%s\n", id, text, normalized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAudioFolderManifest(w *bufio.Writer, dir string, items []AudioWithTranscript) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"file_name", "transcription", "normalized_transcription", "duration", "source_url", "source_offset", "chapter"}); err != nil {
+		return err
+	}
+	for _, it := range items {
+		rel, err := filepath.Rel(dir, it.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%s is not inside %s", it.Path, dir)
+		}
+		if err := cw.Write([]string{
+			filepath.ToSlash(rel),
+			oneLine(it.Text),
+			oneLine(it.NormalizedText),
+			strconv.FormatFloat(it.Duration.Seconds(), 'f', 3, 64),
+			it.Source.URL,
+			strconv.FormatFloat(it.Source.Offset.Seconds(), 'f', 3, 64),
+			it.Source.Chapter,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// oneLine trims text and collapses the whitespace and pipes that would break
+// line oriented manifests.
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(text, "|", " ")), " ")
+}
+
+// ljspeechID names it by its path relative to dir, without the extension
+// and with separators replaced by underscores. Segment files of different
+// sources share names, but their directories, named after the source, do not.
+func ljspeechID(dir string, it AudioWithTranscript) (string, error) {
+	rel, err := filepath.Rel(dir, it.Path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not inside %s", it.Path, dir)
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "_"), nil
+}
+
+// linkFile makes dst a hard link to src, or a symbolic link where the two
+// are on different file systems, replacing whatever dst was.
+func linkFile(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, dst)
+}
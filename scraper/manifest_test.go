@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLJSpeechManifestLinksWavs(t *testing.T) {
+	root := t.TempDir()
+	// Two sources whose segment files share names, one split into chapters.
+	paths := []string{
+		filepath.Join(root, "abc", "vocals_segments", "segment_000_0.00s_1.00s.wav"),
+		filepath.Join(root, "xyz", "vocals_segments", "segment_000_0.00s_1.00s.wav"),
+		filepath.Join(root, "xyz_chapter-1a2b3c4d", "chapters", "xyz_chapter_001", "vocals_segments", "segment_000_0.00s_1.00s.wav"),
+	}
+	var items []AudioWithTranscript
+	for i, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, AudioWithTranscript{
+			Audio: Audio{Path: path, Duration: time.Second},
+			Text:  "one | two",
+		})
+	}
+
+	// Write twice, as a second run would, and a split of the same items.
+	for _, name := range []string{"ljspeech.csv", "ljspeech.csv", "ljspeech.train.csv"} {
+		manifest := filepath.Join(root, name)
+		if err := WriteManifest(manifest, ManifestLJSpeech, items); err != nil {
+			t.Fatalf("WriteManifest %s: %v", name, err)
+		}
+		data, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(lines) != len(items) {
+			t.Fatalf("%s has %d rows, want %d", name, len(lines), len(items))
+		}
+		seen := make(map[string]bool)
+		for i, line := range lines {
+			cols := strings.Split(line, "|")
+			if len(cols) != 3 || cols[1] != "one two" || cols[2] != "one two" {
+				t.Errorf("%s row %d = %q", name, i, line)
+				continue
+			}
+			id := cols[0]
+			if seen[id] {
+				t.Errorf("%s: id %s is used twice", name, id)
+			}
+			seen[id] = true
+			got, err := os.ReadFile(filepath.Join(root, "wavs", id+".wav"))
+			if err != nil {
+				t.Errorf("%s: id %s does not resolve: %v", name, id, err)
+				continue
+			}
+			if !bytes.Equal(got, []byte{byte(i)}) {
+				t.Errorf("%s: wavs/%s.wav is not %s", name, id, paths[i])
+			}
+		}
+	}
+}
+
+func TestWriteLJSpeechManifestErrors(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "segment.wav")
+	if err := os.WriteFile(outside, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"outside the manifest directory", outside},
+		{"missing audio", filepath.Join(root, "abc", "missing.wav")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := []AudioWithTranscript{{Audio: Audio{Path: tt.path}, Text: "x"}}
+			if err := WriteManifest(filepath.Join(root, "ljspeech.csv"), ManifestLJSpeech, items); err == nil {
+				t.Error("WriteManifest succeeded")
+			}
+		})
+	}
+}
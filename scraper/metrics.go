@@ -0,0 +1,98 @@
+package scraper
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// silenceDBFS: Frames quieter than this count as silence
+	silenceDBFS = -45.0
+	// metricsFloorDBFS: Level reported for digital silence, keeping the metrics finite
+	metricsFloorDBFS = -120.0
+	// snrPercentile: Signal and noise power are taken at this percentile from the top and bottom of the frame levels
+	snrPercentile = 0.1
+)
+
+// SegmentMetrics are signal level measurements of a segment, taken over the
+// span of the segmented audio that was cut for it, padding included. Levels
+// are in dB relative to full scale.
+type SegmentMetrics struct {
+	RMSDBFS  float64 `json:"rms_dbfs"`
+	PeakDBFS float64 `json:"peak_dbfs"`
+	// ClippingRatio is the fraction of samples at or near full scale in at
+	// least one channel.
+	ClippingRatio float64 `json:"clipping_ratio"`
+	// SNRDB estimates the signal to noise ratio from the loudest and
+	// quietest frames of the segment.
+	SNRDB float64 `json:"snr_db"`
+	// SilenceRatio is the fraction of frames below silenceDBFS.
+	SilenceRatio float64 `json:"silence_ratio"`
+	// ResidualMusicDB is the level of the accompaniment stem relative to the
+	// segment over the same span; only set when the accompaniment is known.
+	// Values near or above 0 mean the music is as loud as the voice.
+	ResidualMusicDB *float64 `json:"residual_music_db,omitempty"`
+}
+
+// measureSegment computes the metrics of [start, end) from env, and the
+// residual music level from acc if it is not nil.
+func measureSegment(env, acc *envelope, start, end float64) *SegmentMetrics {
+	lo, hi := env.frames(start, end)
+	m := &SegmentMetrics{
+		RMSDBFS:  metricsFloorDBFS,
+		PeakDBFS: metricsFloorDBFS,
+	}
+	if hi <= lo {
+		return m
+	}
+
+	var (
+		power   float64
+		peak    float32
+		clipped int64
+		silent  int
+	)
+	levels := make([]float64, 0, hi-lo)
+	for i := lo; i < hi; i++ {
+		p := float64(env.RMS[i]) * float64(env.RMS[i])
+		power += p
+		levels = append(levels, p)
+		peak = max(peak, env.Peak[i])
+		clipped += int64(env.Clipped[i])
+		if powerDB(p) < silenceDBFS {
+			silent++
+		}
+	}
+	n := hi - lo
+	power /= float64(n)
+	m.RMSDBFS = powerDB(power)
+	m.PeakDBFS = powerDB(float64(peak) * float64(peak))
+	m.ClippingRatio = float64(clipped) / float64(n*env.Frame)
+	m.SilenceRatio = float64(silent) / float64(n)
+
+	sort.Float64s(levels)
+	k := int(float64(n-1) * snrPercentile)
+	m.SNRDB = powerDB(levels[n-1-k]) - powerDB(levels[k])
+
+	if acc != nil {
+		alo, ahi := acc.frames(start, end)
+		if ahi > alo {
+			var accPower float64
+			for i := alo; i < ahi; i++ {
+				accPower += float64(acc.RMS[i]) * float64(acc.RMS[i])
+			}
+			residual := powerDB(accPower/float64(ahi-alo)) - m.RMSDBFS
+			m.ResidualMusicDB = &residual
+		}
+	}
+	return m
+}
+
+// powerDB converts a mean square level to dBFS, flooring digital silence at
+// metricsFloorDBFS.
+func powerDB(p float64) float64 {
+	if p <= 0 {
+		return metricsFloorDBFS
+	}
+	return max(10*math.Log10(p), metricsFloorDBFS)
+}
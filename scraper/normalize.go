@@ -0,0 +1,344 @@
+package scraper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PunctuationPolicy decides which punctuation survives text normalization.
+type PunctuationPolicy string
+
+const (
+	// PunctuationKeep leaves punctuation untouched.
+	PunctuationKeep PunctuationPolicy = "keep"
+	// PunctuationBasic keeps sentence punctuation (. , ? ! ' -) and drops
+	// quotes, brackets, music notes and other symbols.
+	PunctuationBasic PunctuationPolicy = "basic"
+	// PunctuationStrip drops all punctuation except apostrophes and hyphens
+	// inside words.
+	PunctuationStrip PunctuationPolicy = "strip"
+)
+
+// CaseMode selects the letter case of normalized text.
+type CaseMode string
+
+const (
+	CaseKeep  CaseMode = "keep"
+	CaseLower CaseMode = "lower"
+	CaseUpper CaseMode = "upper"
+)
+
+// Replacement rewrites From to To before the other normalization steps run.
+type Replacement struct {
+	// From: Text to replace; a regular expression when Regexp is set
+	From string `json:"from"`
+	// To: Replacement text; may use $1 style groups when Regexp is set
+	To string `json:"to"`
+	// Regexp: Treat From as a regular expression
+	Regexp bool `json:"regexp"`
+}
+
+// TextOptions controls how Segment derives the normalized transcript of each
+// segment. The raw transcript is always kept alongside it.
+type TextOptions struct {
+	// Replacements: Rules applied in order, after unicode normalization
+	Replacements []Replacement `json:"replacements"`
+	// SpellNumbers: Write digits out as English words ("42" -> "forty-two")
+	SpellNumbers bool `json:"spell_numbers"`
+	// Punctuation: Which punctuation to keep
+	Punctuation PunctuationPolicy `json:"punctuation"`
+	// Case: Letter case of the result
+	Case CaseMode `json:"case"`
+}
+
+// DefaultTextOptions keeps casing, spells out numbers and drops symbol noise.
+func DefaultTextOptions() TextOptions {
+	return TextOptions{
+		SpellNumbers: true,
+		Punctuation:  PunctuationBasic,
+		Case:         CaseKeep,
+	}
+}
+
+// TextNormalizer turns raw transcript text into normalized text. Build one
+// with NewTextNormalizer.
+type TextNormalizer struct {
+	opts  TextOptions
+	rules []func(string) string
+}
+
+// NewTextNormalizer validates opts and compiles its replacement rules.
+func NewTextNormalizer(opts TextOptions) (*TextNormalizer, error) {
+	switch opts.Punctuation {
+	case "", PunctuationKeep, PunctuationBasic, PunctuationStrip:
+	default:
+		return nil, fmt.Errorf("unknown punctuation policy %q", opts.Punctuation)
+	}
+	switch opts.Case {
+	case "", CaseKeep, CaseLower, CaseUpper:
+	default:
+		return nil, fmt.Errorf("unknown case mode %q", opts.Case)
+	}
+
+	n := &TextNormalizer{opts: opts}
+	for _, r := range opts.Replacements {
+		if r.From == "" {
+			return nil, fmt.Errorf("replacement to %q has an empty pattern", r.To)
+		}
+		if !r.Regexp {
+			from, to := r.From, r.To
+			n.rules = append(n.rules, func(s string) string { return strings.ReplaceAll(s, from, to) })
+			continue
+		}
+		re, err := regexp.Compile(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("replacement %q: %w", r.From, err)
+		}
+		to := r.To
+		n.rules = append(n.rules, func(s string) string { return re.ReplaceAllString(s, to) })
+	}
+	return n, nil
+}
+
+// Normalize applies, in order: unicode NFC, the replacement rules, number
+// spelling, the punctuation policy, casing and whitespace collapsing.
+func (n *TextNormalizer) Normalize(text string) string {
+	s := norm.NFC.String(text)
+	for _, rule := range n.rules {
+		s = rule(s)
+	}
+	if n.opts.SpellNumbers {
+		s = spellNumbers(s)
+	}
+	s = applyPunctuation(s, n.opts.Punctuation)
+	switch n.opts.Case {
+	case CaseLower:
+		s = strings.ToLower(s)
+	case CaseUpper:
+		s = strings.ToUpper(s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// applyPunctuation drops the punctuation and symbols policy does not allow.
+// Dropped characters become spaces so they never glue two words together.
+// Dots, commas and colons between digits always survive.
+func applyPunctuation(s string, policy PunctuationPolicy) string {
+	if policy == "" || policy == PunctuationKeep {
+		return s
+	}
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if r == '\'' || r == '’' || r == '-' {
+			// Apostrophes and hyphens only survive inside words.
+			if i > 0 && i < len(runes)-1 && isWordRune(runes[i-1]) && isWordRune(runes[i+1]) {
+				if r == '’' {
+					r = '\''
+				}
+				b.WriteRune(r)
+				continue
+			}
+		} else if strings.ContainsRune(".,:", r) && i > 0 && i < len(runes)-1 && unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1]) {
+			// Separators inside numbers left unspelled, as in 1.2.3 or 1:02:03.
+			b.WriteRune(r)
+			continue
+		} else if policy == PunctuationBasic && strings.ContainsRune(".,?!", r) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// numberPattern matches integers, with optional thousands separators, and
+// decimals, optionally followed by an English ordinal suffix, times of day
+// such as 10:30, and tokens of three or more dot or colon separated numbers
+// (versions, dates, addresses, timestamps), each with an optional leading
+// minus sign.
+var numberPattern = regexp.MustCompile(`[-−]?(?:\d+(?:[.:]\d+){2,}|\d{1,2}:\d{2}|\d{1,3}(?:,\d{3})+(?:\.\d+)?(?:st|nd|rd|th)?|\d+(?:\.\d+)?(?:st|nd|rd|th)?)`)
+
+// spellNumbers writes every number in s out in English words. Digits glued
+// to letters ("mp3", "4k"), numbers too large to spell and multi-part tokens
+// such as "1.2.3" are left alone. A minus sign is read as "minus" unless it
+// follows a word, as in "covid-19" or "5-3".
+func spellNumbers(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range numberPattern.FindAllStringIndex(s, -1) {
+		start, end := loc[0], loc[1]
+		if r, size := utf8.DecodeRuneInString(s[start:]); !unicode.IsDigit(r) && start > 0 && isWordRune(lastRune(s[:start])) {
+			start += size
+		}
+		if (start > 0 && isWordRune(lastRune(s[:start]))) || (end < len(s) && isWordRune(firstRune(s[end:]))) {
+			continue
+		}
+		if words, ok := spellNumber(s[start:end]); ok {
+			b.WriteString(s[last:start])
+			b.WriteString(words)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func spellNumber(m string) (string, bool) {
+	if r, size := utf8.DecodeRuneInString(m); !unicode.IsDigit(r) {
+		words, ok := spellNumber(m[size:])
+		return "minus " + words, ok
+	}
+	if strings.Count(m, ".")+strings.Count(m, ":") >= 2 {
+		return "", false
+	}
+	if hours, minutes, ok := strings.Cut(m, ":"); ok {
+		return timeWords(hours, minutes)
+	}
+	ordinal := false
+	if l := len(m); l > 2 && !unicode.IsDigit(rune(m[l-1])) {
+		m, ordinal = m[:l-2], true
+	}
+	whole, frac, _ := strings.Cut(strings.ReplaceAll(m, ",", ""), ".")
+	v, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || v >= 1e15 {
+		return "", false
+	}
+	words := numberWords(v)
+	switch {
+	case frac != "":
+		digits := make([]string, 0, len(frac))
+		for _, d := range frac {
+			digits = append(digits, smallNumbers[d-'0'])
+		}
+		return words + " point " + strings.Join(digits, " "), true
+	case ordinal:
+		return ordinalWords(words), true
+	}
+	return words, true
+}
+
+// timeWords reads a time of day the way it is spoken: 10:30 as "ten thirty",
+// 9:05 as "nine oh five", 7:00 as "seven o'clock" and 17:00 as "seventeen
+// hundred". Other pairs, such as scores, are read as two numbers.
+func timeWords(hours, minutes string) (string, bool) {
+	h, err := strconv.ParseInt(hours, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	m, err := strconv.ParseInt(minutes, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case h > 24 || m > 59:
+		return numberWords(h) + " " + numberWords(m), true
+	case m == 0 && h <= 12:
+		return numberWords(h) + " o'clock", true
+	case m == 0:
+		return numberWords(h) + " hundred", true
+	case m < 10:
+		return numberWords(h) + " oh " + numberWords(m), true
+	}
+	return numberWords(h) + " " + numberWords(m), true
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+var (
+	smallNumbers = []string{
+		"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+		"seventeen", "eighteen", "nineteen",
+	}
+	tensWords  = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	scaleWords = []struct {
+		value int64
+		name  string
+	}{
+		{1e12, "trillion"}, {1e9, "billion"}, {1e6, "million"}, {1e3, "thousand"},
+	}
+)
+
+// numberWords spells out a non-negative integer, e.g. 1042 as
+// "one thousand forty-two".
+func numberWords(v int64) string {
+	if v < 20 {
+		return smallNumbers[v]
+	}
+	if v < 100 {
+		if v%10 == 0 {
+			return tensWords[v/10]
+		}
+		return tensWords[v/10] + "-" + smallNumbers[v%10]
+	}
+	if v < 1000 {
+		s := smallNumbers[v/100] + " hundred"
+		if v%100 != 0 {
+			s += " " + numberWords(v%100)
+		}
+		return s
+	}
+	for _, sc := range scaleWords {
+		if v >= sc.value {
+			s := numberWords(v/sc.value) + " " + sc.name
+			if v%sc.value != 0 {
+				s += " " + numberWords(v%sc.value)
+			}
+			return s
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// ordinalWords turns spelled out cardinal words into their ordinal form by
+// rewriting the last word: "twenty-one" -> "twenty-first".
+func ordinalWords(words string) string {
+	cut := strings.LastIndexAny(words, " -") + 1
+	head, last := words[:cut], words[cut:]
+	switch last {
+	case "one":
+		last = "first"
+	case "two":
+		last = "second"
+	case "three":
+		last = "third"
+	case "five":
+		last = "fifth"
+	case "eight":
+		last = "eighth"
+	case "nine":
+		last = "ninth"
+	case "twelve":
+		last = "twelfth"
+	default:
+		if strings.HasSuffix(last, "y") {
+			last = strings.TrimSuffix(last, "y") + "ieth"
+		} else {
+			last += "th"
+		}
+	}
+	return head + last
+}
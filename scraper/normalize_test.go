@@ -0,0 +1,77 @@
+package scraper
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		// opts default to DefaultTextOptions.
+		opts *TextOptions
+		in   string
+		want string
+	}{
+		{name: "cardinals", in: "42 apples and 1042 pears", want: "forty-two apples and one thousand forty-two pears"},
+		{name: "ordinals", in: "the 1st, 2nd, 3rd, 12th and 21st", want: "the first, second, third, twelfth and twenty-first"},
+		{name: "thousands separators", in: "1,000,000 stars and 2,500.75 coins", want: "one million stars and two thousand five hundred point seven five coins"},
+		{name: "ordinal with separators", in: "the 1,000th visitor", want: "the one thousandth visitor"},
+		{name: "decimals", in: "pi is 3.14.", want: "pi is three point one four."},
+		{name: "negatives", in: "-5 degrees, or −0.5", want: "minus five degrees, or minus zero point five"},
+		{name: "hyphens after words are not signs", in: "covid-19 and a 5-3 win", want: "covid-nineteen and a five-three win"},
+		{name: "dotted versions are left alone", in: "version 1.2.3 on 192.168.0.1", want: "version 1.2.3 on 192.168.0.1"},
+		{name: "dotted dates are left alone", in: "born 24.12.1990", want: "born 24.12.1990"},
+		{name: "times", in: "at 10:30, 9:05, 7:00 and 17:00", want: "at ten thirty, nine oh five, seven o'clock and seventeen hundred"},
+		{name: "timestamps are left alone", in: "skip to 1:02:03", want: "skip to 1:02:03"},
+		{name: "digits glued to letters", in: "an mp3 in 4k", want: "an mp3 in 4k"},
+		{name: "symbols are dropped", in: "♪ la \"la\" (la) ♪", want: "la la la"},
+		{
+			name: "strip keeps separators inside numbers",
+			opts: &TextOptions{Punctuation: PunctuationStrip},
+			in:   "v. 1.2.3, at 1:02:03!",
+			want: "v 1.2.3 at 1:02:03",
+		},
+		{
+			name: "strip and lower",
+			opts: &TextOptions{Punctuation: PunctuationStrip, Case: CaseLower},
+			in:   "Don't stop, it's 10!",
+			want: "don't stop it's 10",
+		},
+		{
+			name: "replacements run first",
+			opts: &TextOptions{Replacements: []Replacement{{From: "&", To: " and "}, {From: `\bgonna\b`, To: "going to", Regexp: true}}, SpellNumbers: true},
+			in:   "rock & roll, gonna 2",
+			want: "rock and roll, going to two",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultTextOptions()
+			if tt.opts != nil {
+				opts = *tt.opts
+			}
+			n, err := NewTextNormalizer(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTextNormalizerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TextOptions
+	}{
+		{"unknown punctuation", TextOptions{Punctuation: "some"}},
+		{"unknown case", TextOptions{Case: "title"}},
+		{"empty pattern", TextOptions{Replacements: []Replacement{{To: "x"}}}},
+		{"bad regexp", TextOptions{Replacements: []Replacement{{From: "(", Regexp: true}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewTextNormalizer(tt.opts); err == nil {
+			t.Errorf("%s: NewTextNormalizer succeeded", tt.name)
+		}
+	}
+}
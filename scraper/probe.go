@@ -0,0 +1,254 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tcolgate/mp3"
+)
+
+// AudioInfo is the technical description of an audio file.
+type AudioInfo struct {
+	Format     Format        `json:"format"`
+	Duration   time.Duration `json:"duration"`
+	SampleRate int           `json:"sample_rate"`
+	Channels   int           `json:"channels"`
+	Codec      string        `json:"codec"`
+	// BitRate is in bits per second. For compressed formats it is the
+	// average over the whole file.
+	BitRate int `json:"bit_rate"`
+}
+
+// FormatFromPath guesses the Format of a file from its extension.
+func FormatFromPath(path string) Format {
+	return Format(strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")))
+}
+
+// Probe inspects the audio file at path. WAV, FLAC and MP3 are parsed
+// natively; anything else, or a file the native parsers reject, is handed to
+// ffprobe.
+func Probe(ctx context.Context, path string) (*AudioInfo, error) {
+	var (
+		info *AudioInfo
+		err  error
+	)
+	switch FormatFromPath(path) {
+	case FormatWAV:
+		info, err = probeWAV(path)
+	case FormatFLAC:
+		info, err = probeFLAC(path)
+	case FormatMP3:
+		info, err = probeMP3(path)
+	default:
+		return probeFFprobe(ctx, path)
+	}
+	if err != nil {
+		return probeFFprobe(ctx, path)
+	}
+	return info, nil
+}
+
+// ProbeAudio is a convenience wrapper around Probe that returns an *Audio
+// for path.
+func ProbeAudio(ctx context.Context, path string) (*Audio, error) {
+	info, err := Probe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Audio{Path: path, Duration: info.Duration, Format: info.Format}, nil
+}
+
+func probeWAV(path string) (*AudioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h, err := readWAVHeader(f, st.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &AudioInfo{
+		Format:     FormatWAV,
+		Duration:   h.Duration(),
+		SampleRate: h.SampleRate,
+		Channels:   h.Channels,
+		Codec:      h.Codec(),
+		BitRate:    h.ByteRate * 8,
+	}, nil
+}
+
+func probeFLAC(path string) (*AudioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := skipID3v2(f); err != nil {
+		return nil, err
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return nil, fmt.Errorf("read flac magic: %w", err)
+	}
+	if string(magic[:]) != "fLaC" {
+		return nil, errors.New("not a FLAC file")
+	}
+
+	// The first metadata block is always STREAMINFO.
+	var block [4 + 34]byte
+	if _, err := io.ReadFull(f, block[:]); err != nil {
+		return nil, fmt.Errorf("read streaminfo: %w", err)
+	}
+	if block[0]&0x7F != 0 {
+		return nil, errors.New("first flac metadata block is not STREAMINFO")
+	}
+	si := block[4:]
+	// Bytes 10..17 pack: sample rate (20 bits), channels-1 (3 bits),
+	// bits per sample-1 (5 bits), total samples (36 bits).
+	packed := binary.BigEndian.Uint64(si[10:18])
+	sampleRate := int(packed >> 44)
+	channels := int((packed>>41)&0x7) + 1
+	totalSamples := packed & 0xFFFFFFFFF
+	if sampleRate == 0 {
+		return nil, errors.New("flac streaminfo has zero sample rate")
+	}
+
+	info := &AudioInfo{
+		Format:     FormatFLAC,
+		Duration:   time.Duration(totalSamples) * time.Second / time.Duration(sampleRate),
+		SampleRate: sampleRate,
+		Channels:   channels,
+		Codec:      "flac",
+	}
+	if info.Duration > 0 {
+		info.BitRate = int(float64(st.Size()*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+func probeMP3(path string) (*AudioInfo, error) {
+	f, err := openMP3(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dur, ok := f.headerDuration()
+	if !ok {
+		if dur, err = f.scan(); err != nil {
+			return nil, err
+		}
+	}
+
+	h := f.first.Header()
+	channels := 2
+	if h.ChannelMode() == mp3.SingleChannel {
+		channels = 1
+	}
+	info := &AudioInfo{
+		Format:     FormatMP3,
+		Duration:   dur,
+		SampleRate: int(h.SampleRate()),
+		Channels:   channels,
+		Codec:      "mp3",
+		BitRate:    int(h.BitRate()),
+	}
+	if dur > 0 {
+		info.BitRate = int(float64((f.end-f.firstFrame)*8) / dur.Seconds())
+	}
+	return info, nil
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		BitRate    string `json:"bit_rate"`
+		Duration   string `json:"duration"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func probeFFprobe(ctx context.Context, path string) (*AudioInfo, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-select_streams", "a:0",
+		path,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w – %s", err, stderr.String())
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("%s has no audio stream", path)
+	}
+	s := probe.Streams[0]
+
+	// Stream level values are preferred; containers such as Matroska only
+	// report duration and bit rate on the format.
+	duration := firstNonEmpty(s.Duration, probe.Format.Duration)
+	bitRate := firstNonEmpty(s.BitRate, probe.Format.BitRate)
+
+	info := &AudioInfo{
+		Format:   FormatFromPath(path),
+		Channels: s.Channels,
+		Codec:    s.CodecName,
+	}
+	if secs, err := strconv.ParseFloat(duration, 64); err == nil {
+		info.Duration = secondsToDuration(secs)
+	}
+	if sr, err := strconv.Atoi(s.SampleRate); err == nil {
+		info.SampleRate = sr
+	}
+	if br, err := strconv.Atoi(bitRate); err == nil {
+		info.BitRate = br
+	}
+	return info, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" && v != "N/A" {
+			return v
+		}
+	}
+	return ""
+}
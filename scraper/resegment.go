@@ -0,0 +1,166 @@
+package scraper
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	// resegmentSentenceBonus: Extra weight, in seconds of silence, for splitting after sentence-ending punctuation
+	resegmentSentenceBonus = 0.5
+	// resegmentClauseBonus: Extra weight for splitting after a comma, colon or semicolon
+	resegmentClauseBonus = 0.25
+	// resegmentBoundaryBonus: Extra weight for splitting where whisperx already ended a segment
+	resegmentBoundaryBonus = 0.3
+	// resegmentScoreTolerance: Break scores closer than this are ties
+	resegmentScoreTolerance = 1e-3
+)
+
+// ResegmentOptions describes the target utterance length for Resegment.
+type ResegmentOptions struct {
+	// MinSeconds and MaxSeconds bound the duration of the produced segments.
+	MinSeconds float64 `json:"min_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+	// MaxGapSeconds: A pause at least this long always ends a segment, so
+	// short segments are never merged across long silences
+	MaxGapSeconds float64 `json:"max_gap_seconds"`
+}
+
+// resegmentWord is a word of the flattened transcript together with what we
+// know about the break after it.
+type resegmentWord struct {
+	TimeAlignedWord
+	// boundary is set on the last word of an original whisperx segment.
+	boundary bool
+}
+
+// Resegment rebuilds the segments of tat from its word timings so that they
+// fall into [opts.MinSeconds, opts.MaxSeconds]: short adjacent segments are
+// merged and long ones are split, preferably at long pauses and punctuation.
+// Segments without word timings can not be split and are kept as they are.
+// Segments that are still too short afterwards, typically because they sit
+// between long pauses, are left for the duration filter to drop.
+func Resegment(tat *TimeAlignedTranscript, opts ResegmentOptions) *TimeAlignedTranscript {
+	out := &TimeAlignedTranscript{Language: tat.Language}
+
+	var run []resegmentWord
+	flush := func() {
+		out.Segments = append(out.Segments, splitWords(run, opts)...)
+		run = run[:0]
+	}
+
+	for _, seg := range tat.Segments {
+		if len(seg.Words) == 0 {
+			flush()
+			out.Segments = append(out.Segments, seg)
+			continue
+		}
+
+		for i, w := range seg.Words {
+			// whisperx leaves words it could not align (digits, symbols)
+			// without timestamps; pin them to the previous word.
+			if w.End <= w.Start {
+				switch {
+				case len(run) > 0:
+					w.Start, w.End = run[len(run)-1].End, run[len(run)-1].End
+				case i == 0:
+					w.Start, w.End = seg.Start, seg.Start
+				}
+			}
+			if len(run) > 0 && opts.MaxGapSeconds > 0 && w.Start-run[len(run)-1].End >= opts.MaxGapSeconds {
+				flush()
+			}
+			run = append(run, resegmentWord{TimeAlignedWord: w})
+		}
+		run[len(run)-1].boundary = true
+	}
+	flush()
+	return out
+}
+
+// splitWords cuts a run of words, with no forced breaks inside it, into
+// segments of the target length. Among equally good breaks the one nearest
+// an even split of the rest of the run wins, so evenly spaced words are not
+// cut into back-to-back MinSeconds segments.
+func splitWords(words []resegmentWord, opts ResegmentOptions) []TranscriptSegment {
+	var segs []TranscriptSegment
+	for i := 0; i < len(words); {
+		last := len(words) - 1
+		total := words[last].End - words[i].Start
+		if opts.MaxSeconds <= 0 || total <= opts.MaxSeconds {
+			segs = append(segs, makeSegment(words[i:]))
+			break
+		}
+		target := total / math.Ceil(total/opts.MaxSeconds)
+
+		// Pick the best place to break within the allowed window. Prefer
+		// breaks that leave a long enough remainder.
+		var (
+			best, fallback = -1, i
+			bestScore      float64
+			bestDur        float64
+			bestLeavesTail bool
+		)
+		for j := i; j < last; j++ {
+			d := words[j].End - words[i].Start
+			if d > opts.MaxSeconds {
+				break
+			}
+			fallback = j
+			if d < opts.MinSeconds {
+				continue
+			}
+			leavesTail := words[last].End-words[j+1].Start >= opts.MinSeconds
+			score := breakScore(words[j], words[j+1])
+			better := best == -1 || (leavesTail && !bestLeavesTail)
+			if !better && leavesTail == bestLeavesTail {
+				switch {
+				case score > bestScore+resegmentScoreTolerance:
+					better = true
+				case score > bestScore-resegmentScoreTolerance:
+					better = math.Abs(d-target) < math.Abs(bestDur-target)
+				}
+			}
+			if better {
+				best, bestScore, bestDur, bestLeavesTail = j, score, d, leavesTail
+			}
+		}
+		if best == -1 {
+			best = fallback
+		}
+
+		segs = append(segs, makeSegment(words[i:best+1]))
+		i = best + 1
+	}
+	return segs
+}
+
+// breakScore rates how natural a break between cur and next is.
+func breakScore(cur, next resegmentWord) float64 {
+	score := max(next.Start-cur.End, 0)
+	switch w := strings.TrimSpace(cur.Word); {
+	case strings.HasSuffix(w, "."), strings.HasSuffix(w, "!"), strings.HasSuffix(w, "?"):
+		score += resegmentSentenceBonus
+	case strings.HasSuffix(w, ","), strings.HasSuffix(w, ";"), strings.HasSuffix(w, ":"):
+		score += resegmentClauseBonus
+	}
+	if cur.boundary {
+		score += resegmentBoundaryBonus
+	}
+	return score
+}
+
+func makeSegment(words []resegmentWord) TranscriptSegment {
+	seg := TranscriptSegment{
+		Start: words[0].Start,
+		End:   words[len(words)-1].End,
+		Words: make([]TimeAlignedWord, len(words)),
+	}
+	text := make([]string, len(words))
+	for i, w := range words {
+		seg.Words[i] = w.TimeAlignedWord
+		text[i] = strings.TrimSpace(w.Word)
+	}
+	seg.Text = strings.Join(text, " ")
+	return seg
+}
@@ -0,0 +1,134 @@
+package scraper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// spacedSegment is a whisperx segment of the given words, each dur seconds
+// long and starting every step seconds from start.
+func spacedSegment(start, step, dur float64, words ...string) TranscriptSegment {
+	seg := TranscriptSegment{Text: strings.Join(words, " "), Start: start}
+	for k, w := range words {
+		s := start + float64(k)*step
+		seg.Words = append(seg.Words, TimeAlignedWord{Start: s, End: s + dur, Word: w})
+	}
+	seg.End = seg.Words[len(seg.Words)-1].End
+	return seg
+}
+
+// segmentSummary renders segments as "start-end text" for comparison.
+func segmentSummary(segs []TranscriptSegment) []string {
+	var out []string
+	for _, s := range segs {
+		out = append(out, fmt.Sprintf("%.1f-%.1f %s", s.Start, s.End, s.Text))
+	}
+	return out
+}
+
+func TestResegment(t *testing.T) {
+	opts := ResegmentOptions{MinSeconds: 1, MaxSeconds: 4, MaxGapSeconds: 2}
+	many := func(n int) []string {
+		words := make([]string, n)
+		for i := range words {
+			words[i] = fmt.Sprintf("w%d", i)
+		}
+		return words
+	}
+
+	tests := []struct {
+		name string
+		segs []TranscriptSegment
+		opts ResegmentOptions
+		want []string
+	}{
+		{
+			name: "short segments are merged",
+			segs: []TranscriptSegment{
+				spacedSegment(0, 0.3, 0.2, "one", "two"),
+				spacedSegment(0.7, 0.3, 0.2, "three", "four"),
+			},
+			opts: opts,
+			want: []string{"0.0-1.2 one two three four"},
+		},
+		{
+			name: "long pauses are never bridged",
+			segs: []TranscriptSegment{
+				spacedSegment(0, 0.3, 0.2, "one", "two"),
+				spacedSegment(3, 0.3, 0.2, "three", "four"),
+			},
+			opts: opts,
+			want: []string{"0.0-0.5 one two", "3.0-3.5 three four"},
+		},
+		{
+			name: "long segments break after punctuation",
+			segs: []TranscriptSegment{spacedSegment(0, 0.5, 0.4, "a", "b", "c", "done.", "e", "f", "g", "h", "i")},
+			opts: opts,
+			want: []string{"0.0-1.9 a b c done.", "2.0-4.4 e f g h i"},
+		},
+		{
+			name: "long segments break at the longest pause",
+			segs: []TranscriptSegment{
+				{Text: "a b c d e f", Start: 0, End: 5, Words: []TimeAlignedWord{
+					{Start: 0, End: 0.8, Word: "a"}, {Start: 0.9, End: 1.7, Word: "b"},
+					{Start: 2.5, End: 3.0, Word: "c"}, {Start: 3.1, End: 3.6, Word: "d"},
+					{Start: 3.7, End: 4.3, Word: "e"}, {Start: 4.4, End: 5, Word: "f"},
+				}},
+			},
+			opts: opts,
+			want: []string{"0.0-1.7 a b", "2.5-5.0 c d e f"},
+		},
+		{
+			name: "equal breaks do not collapse to the minimum length",
+			segs: []TranscriptSegment{spacedSegment(0, 0.5, 0.4, many(20)...)},
+			opts: opts,
+			want: []string{
+				"0.0-3.4 w0 w1 w2 w3 w4 w5 w6",
+				"3.5-6.9 w7 w8 w9 w10 w11 w12 w13",
+				"7.0-9.9 w14 w15 w16 w17 w18 w19",
+			},
+		},
+		{
+			name: "breaks leave a tail of at least the minimum",
+			segs: []TranscriptSegment{spacedSegment(0, 0.5, 0.4, "a", "b", "c", "d", "e", "f", "g", "done.", "i")},
+			opts: opts,
+			want: []string{"0.0-2.4 a b c d e", "2.5-4.4 f g done. i"},
+		},
+		{
+			name: "segments without words are kept",
+			segs: []TranscriptSegment{
+				spacedSegment(0, 0.3, 0.2, "one"),
+				{Text: "♪", Start: 1, End: 2},
+				spacedSegment(2.2, 0.3, 0.2, "two"),
+			},
+			opts: opts,
+			want: []string{"0.0-0.2 one", "1.0-2.0 ♪", "2.2-2.4 two"},
+		},
+		{
+			name: "unaligned words follow the previous word",
+			segs: []TranscriptSegment{{Text: "take 5 now", Start: 0, End: 1, Words: []TimeAlignedWord{
+				{Start: 0, End: 0.4, Word: "take"}, {Word: "5"}, {Start: 0.6, End: 1, Word: "now"},
+			}}},
+			opts: opts,
+			want: []string{"0.0-1.0 take 5 now"},
+		},
+		{
+			name: "no maximum only merges",
+			segs: []TranscriptSegment{spacedSegment(0, 0.5, 0.4, many(20)...)},
+			opts: ResegmentOptions{MinSeconds: 1},
+			want: []string{"0.0-9.9 " + strings.Join(many(20), " ")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Resegment(&TimeAlignedTranscript{Segments: tt.segs, Language: "en"}, tt.opts)
+			if got.Language != "en" {
+				t.Errorf("language = %q", got.Language)
+			}
+			if g := segmentSummary(got.Segments); strings.Join(g, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Resegment =\n%s\nwant\n%s", strings.Join(g, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -1,29 +1,129 @@
 package scraper
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
+	"strings"
 )
 
 const (
-	// confidenceThreshold: Below this threshold, we assume that the whisperx transcription is not reliable
-	confidenceThreshold = 0.60
-	// consecutiveLowConfidenceThreshold: If there are more than this number of consecutive low confidence words, we skip the segment
-	consecutiveLowConfidenceThreshold = 3
-	// percentageLowConfidenceThresholdWithinSegment: If more than this percentage of words have low confidence, we skip the segment
-	percentageLowConfidenceThresholdWithinSegment = 0.50
-	// minSegmentDurationSeconds: Skip segments shorter than this duration
-	minSegmentDurationSeconds = 2.0
-	// outputSegmentFormat: The audio format for the output segments
-	outputSegmentFormat = FormatWAV // Assuming WAV output, adjust if needed
-	outputSegmentExt    = ".wav"    // File extension for the output format
+	// snapHopSeconds: Resolution of the energy envelope used to snap boundaries to silence
+	snapHopSeconds = 0.01
 )
 
-func Segment(audio *Audio, tat *TimeAlignedTranscript) ([]AudioWithTranscript, error) {
+// SegmentOptions controls which transcript segments Segment keeps and how
+// they are cut. Durations are in seconds, like the whisperx timestamps they
+// are compared against.
+type SegmentOptions struct {
+	// ConfidenceThreshold: Below this threshold, we assume that the whisperx transcription is not reliable
+	ConfidenceThreshold float64 `json:"confidence_threshold"`
+	// ConsecutiveLowConfidenceThreshold: If there are this many or more consecutive low confidence words, we skip the segment
+	ConsecutiveLowConfidenceThreshold int `json:"consecutive_low_confidence_threshold"`
+	// MaxLowConfidenceRatio: If more than this fraction of words have low confidence, we skip the segment
+	MaxLowConfidenceRatio float64 `json:"max_low_confidence_ratio"`
+	// MinDurationSeconds: Skip segments shorter than this duration
+	MinDurationSeconds float64 `json:"min_duration_seconds"`
+	// MaxDurationSeconds: Skip segments longer than this duration; 0 disables the check
+	MaxDurationSeconds float64 `json:"max_duration_seconds"`
+	// MinWords: Skip segments with fewer words than this
+	MinWords int `json:"min_words"`
+	// MinCharsPerSecond, MaxCharsPerSecond: Skip segments speaking slower or faster than this; 0 disables the bound
+	MinCharsPerSecond float64 `json:"min_chars_per_second"`
+	MaxCharsPerSecond float64 `json:"max_chars_per_second"`
+	// NonSpeechTokens: Skip segments whose text contains any of these, e.g. "[Music]"
+	NonSpeechTokens []string `json:"non_speech_tokens"`
+	// ProfanityWords: Skip segments containing any of these words
+	ProfanityWords []string `json:"profanity_words"`
+	// Languages: Skip transcripts whose detected language is not listed; empty accepts all
+	Languages []string `json:"languages"`
+	// Mode: How the transcript is split into utterances, see SegmentMode
+	Mode SegmentMode `json:"mode"`
+	// Lyrics: Line splitting settings used in SegmentModeLyrics
+	Lyrics LyricOptions `json:"lyrics"`
+	// Text: How NormalizedText is derived from the raw transcript
+	Text TextOptions `json:"text"`
+	// Output: Encoding of the cut segments
+	Output OutputOptions `json:"output"`
+	// Resegment: Rebuild whisperx segments into this length window before filtering; disabled unless max_seconds is set
+	Resegment ResegmentOptions `json:"resegment"`
+	// PrePaddingSeconds, PostPaddingSeconds: Extra audio kept before and after each segment, clamped to the
+	// neighbouring transcript segments and to the input audio
+	PrePaddingSeconds  float64 `json:"pre_padding_seconds"`
+	PostPaddingSeconds float64 `json:"post_padding_seconds"`
+	// PaddingSeconds: Kept so older configs still pad; it applies to whichever of PrePaddingSeconds and
+	// PostPaddingSeconds is left at zero
+	//
+	// Deprecated: use PrePaddingSeconds and PostPaddingSeconds.
+	PaddingSeconds float64 `json:"padding_seconds"`
+	// SnapToSilence: Move each padded boundary to the quietest point within SnapWindowSeconds of it, never
+	// cutting into the transcribed speech or past a neighbouring segment
+	SnapToSilence     bool    `json:"snap_to_silence"`
+	SnapWindowSeconds float64 `json:"snap_window_seconds"`
+	// Metrics: Measure signal metrics for every segment over the span that is cut, padding and snapping
+	// included; also required by the metric filters below
+	Metrics bool `json:"metrics"`
+	// Accompaniment: The accompaniment stem separated from the audio being segmented; residual music is only
+	// measured when it is set
+	Accompaniment *Audio `json:"-"`
+	// CutAccompaniment: Also cut each segment's span from Accompaniment, for paired vocals/instrumental data
+	CutAccompaniment bool `json:"cut_accompaniment"`
+	// MinRMSDBFS, MaxClippingRatio, MinSNRDB, MaxSilenceRatio, MaxResidualMusicDB: Drop segments whose metrics
+	// are outside these bounds; zero disables each bound
+	MinRMSDBFS         float64 `json:"min_rms_dbfs"`
+	MaxClippingRatio   float64 `json:"max_clipping_ratio"`
+	MinSNRDB           float64 `json:"min_snr_db"`
+	MaxSilenceRatio    float64 `json:"max_silence_ratio"`
+	MaxResidualMusicDB float64 `json:"max_residual_music_db"`
+	// Speech: Speech regions detected in the vocals, which share their timeline with whatever stem is being
+	// segmented; only used by MinSpeechRatio
+	Speech *SpeechActivity `json:"-"`
+	// MinSpeechRatio: Drop segments with less than this fraction of their span inside detected speech, which
+	// catches text whisperx hallucinated over silence or music; 0 disables the check
+	MinSpeechRatio float64 `json:"min_speech_ratio"`
+
+	// ExtraFilters are appended to the built-in filter chain.
+	ExtraFilters []SegmentFilter `json:"-"`
+}
+
+// DefaultSegmentOptions returns the quality bar Segment has always used.
+func DefaultSegmentOptions() SegmentOptions {
+	return SegmentOptions{
+		ConfidenceThreshold:               0.60,
+		ConsecutiveLowConfidenceThreshold: 3,
+		MaxLowConfidenceRatio:             0.50,
+		MinDurationSeconds:                2.0,
+		SnapWindowSeconds:                 0.25,
+		Mode:                              SegmentModeASR,
+		Lyrics:                            DefaultLyricOptions(),
+		Text:                              DefaultTextOptions(),
+		Metrics:                           true,
+		MinSpeechRatio:                    0.5,
+		Output:                            OutputOptions{Format: FormatWAV},
+	}
+}
+
+func Segment(ctx context.Context, audio *Audio, tat *TimeAlignedTranscript, opts SegmentOptions) ([]AudioWithTranscript, error) {
+	if err := opts.Output.validate(); err != nil {
+		return nil, err
+	}
+	normalizer, err := NewTextNormalizer(opts.Text)
+	if err != nil {
+		return nil, fmt.Errorf("text normalization: %w", err)
+	}
+	if opts.PaddingSeconds != 0 {
+		log.Printf("Warning: padding_seconds is deprecated, use pre_padding_seconds and post_padding_seconds")
+		if opts.PrePaddingSeconds == 0 {
+			opts.PrePaddingSeconds = opts.PaddingSeconds
+		}
+		if opts.PostPaddingSeconds == 0 {
+			opts.PostPaddingSeconds = opts.PaddingSeconds
+		}
+	}
+
 	// Create an output directory for the segments
 	// Use the directory of the input audio file
 	baseName := filepath.Base(audio.Path)
@@ -36,100 +136,240 @@ func Segment(audio *Audio, tat *TimeAlignedTranscript) ([]AudioWithTranscript, e
 	log.Printf("Splitting audio file: %s", audio.Path)
 	log.Printf("Output directory: %s", outputDir)
 
-	var resultSegments []AudioWithTranscript
-	for i, seg := range tat.Segments {
-		segmentDuration := seg.End - seg.Start
-
-		// Skip segments that are too short
-		if segmentDuration < minSegmentDurationSeconds {
-			log.Printf("Warning: Segment %d is too short (%.2fs < %.2fs). Skipping.", i, segmentDuration, minSegmentDurationSeconds)
-			continue
+	var lyrics []LyricInfo
+	switch opts.Mode {
+	case SegmentModeLyrics:
+		tat, lyrics = LyricLines(tat, opts.Lyrics)
+		log.Printf("Split transcript into %d lyric lines", len(tat.Segments))
+	case "", SegmentModeASR:
+		if opts.Resegment.MaxSeconds > 0 {
+			before := len(tat.Segments)
+			tat = Resegment(tat, opts.Resegment)
+			log.Printf("Resegmented %d transcript segments into %d", before, len(tat.Segments))
 		}
+	default:
+		return nil, fmt.Errorf("unknown segment mode %q", opts.Mode)
+	}
 
-		// Skip segments with invalid start/end times
-		if seg.Start >= seg.End || seg.Start < 0 {
-			log.Printf("Warning: Segment %d has invalid start/end times (start: %.2fs, end: %.2fs). Skipping.", i, seg.Start, seg.End)
-			continue
+	var (
+		kept  []TranscriptSegment
+		idx   []int
+		spans []cutSpan
+	)
+	// One envelope serves both boundary snapping and the signal metrics.
+	var env, accEnv, snapEnv *envelope
+	if opts.SnapToSilence || opts.Metrics {
+		if env, err = rmsEnvelope(ctx, audio.Path, snapHopSeconds); err != nil {
+			log.Printf("Warning: could not measure energy of %s, not snapping boundaries or measuring segments: %v", audio.Path, err)
 		}
+	}
+	if opts.SnapToSilence {
+		snapEnv = env
+	}
+	if env != nil && opts.Metrics && opts.Accompaniment != nil {
+		if accEnv, err = rmsEnvelope(ctx, opts.Accompaniment.Path, snapHopSeconds); err != nil {
+			log.Printf("Warning: could not measure accompaniment %s, not estimating residual music: %v", opts.Accompaniment.Path, err)
+		}
+	}
+	// The signal filter passes segments without the metrics it needs, so
+	// say when a configured bound can not take effect.
+	if !opts.Metrics && (opts.MinRMSDBFS != 0 || opts.MaxClippingRatio != 0 || opts.MinSNRDB != 0 ||
+		opts.MaxSilenceRatio != 0 || opts.MaxResidualMusicDB != 0) {
+		log.Printf("Warning: signal metric bounds are set but metrics are disabled; not filtering on them")
+	} else if opts.MaxResidualMusicDB != 0 && accEnv == nil {
+		log.Printf("Warning: max_residual_music_db is set but no accompaniment stem was measured; not filtering on residual music")
+	}
+	var metrics []*SegmentMetrics
 
-		// Analyze word confidence if words are present
-		if len(seg.Words) > 0 {
-			lowConfidenceCount := 0
-			consecutiveLowConfidence := 0
-			maxConsecutiveLowConfidence := 0
-
-			for _, word := range seg.Words {
-				if word.ConfidenceScore < confidenceThreshold {
-					lowConfidenceCount++
-					consecutiveLowConfidence++
-				} else {
-					if consecutiveLowConfidence > maxConsecutiveLowConfidence {
-						maxConsecutiveLowConfidence = consecutiveLowConfidence
-					}
-					consecutiveLowConfidence = 0 // Reset counter
-				}
-			}
-			// Check after the loop for the last sequence
-			if consecutiveLowConfidence > maxConsecutiveLowConfidence {
-				maxConsecutiveLowConfidence = consecutiveLowConfidence
-			}
-
-			// Skip if more than threshold percentage of words have low confidence
-			percentageLowConfidence := float64(lowConfidenceCount) / float64(len(seg.Words))
-			if percentageLowConfidence > percentageLowConfidenceThresholdWithinSegment {
-				log.Printf("Warning: Segment %d has >%.0f%% low confidence words (%.2f%%). Skipping.", i, percentageLowConfidenceThresholdWithinSegment*100, percentageLowConfidence*100)
-				continue
-			}
-
-			// Skip if there are threshold+ consecutive low confidence words
-			if maxConsecutiveLowConfidence >= consecutiveLowConfidenceThreshold {
-				log.Printf("Warning: Segment %d has %d+ consecutive low confidence words (%d found). Skipping.", i, consecutiveLowConfidenceThreshold, maxConsecutiveLowConfidence)
-				continue
-			}
+	filters := opts.Filters()
+	report := SegmentReport{Total: len(tat.Segments), Rejected: []Rejection{}}
+	for i, seg := range tat.Segments {
+		// Metrics describe the clip as it will be written, padding included.
+		start, end := segmentBounds(tat.Segments, i, audio.Duration.Seconds(), opts, snapEnv)
+		c := &SegmentCandidate{Index: i, Segment: seg, Language: tat.Language}
+		if env != nil && opts.Metrics {
+			c.Metrics = measureSegment(env, accEnv, start, end)
+		}
+		if rej, ok := applyFilters(filters, c); !ok {
+			log.Printf("Warning: Segment %d rejected by %s: %s. Skipping.", i, rej.Filter, rej.Reason)
+			report.Rejected = append(report.Rejected, *rej)
+			continue
 		}
 
 		// Construct output filename for audio segment
 		// Using index and times for uniqueness
 		segmentBase := fmt.Sprintf("segment_%03d_%.2fs_%.2fs", i, seg.Start, seg.End)
-		audioFilename := segmentBase + outputSegmentExt
+		audioFilename := segmentBase + opts.Output.Ext()
 		outputPath := filepath.Join(outputDir, audioFilename)
 
-		// Use ffmpeg to extract the audio segment
-		// ffmpeg -i <input> -ss <start> -to <end> <output>
-		// Using -to specifies the absolute end time.
-		// Omitting -c copy to ensure output is in the desired format (WAV by default)
-		cmd := exec.Command("ffmpeg",
-			"-i", audio.Path,
-			"-ss", fmt.Sprintf("%f", seg.Start),
-			"-to", fmt.Sprintf("%f", seg.End),
-			// "-c", "copy", // Remove this to re-encode to WAV (or desired format)
-			outputPath,
-		)
-		cmd.Stderr = os.Stderr // Redirect ffmpeg stderr for debugging
-
-		log.Printf("Running command: %s", cmd.String())
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error running ffmpeg for segment %d (start: %.2f, end: %.2f): %v. Skipping.", i, seg.Start, seg.End, err)
-			continue // Skip this segment if ffmpeg fails
-		}
-
 		// Get absolute path for consistency
 		absOutputPath, err := filepath.Abs(outputPath)
 		if err != nil {
 			return nil, fmt.Errorf("warning: could not get absolute path for %s: %v. Using relative path.", outputPath, err)
 		}
 
+		kept = append(kept, seg)
+		metrics = append(metrics, c.Metrics)
+		idx = append(idx, i)
+		spans = append(spans, cutSpan{Start: start, End: end, Path: absOutputPath})
+	}
+
+	// Cut all accepted segments, in one pass over the input when possible
+	errs := cutSpans(ctx, audio, spans, opts.Output)
+
+	// The accompaniment is cut along the same spans, next to each segment.
+	var (
+		accSpans []cutSpan
+		accErrs  []error
+		accOf    = make([]int, len(spans)) // index into accSpans, or -1
+	)
+	for i, sp := range spans {
+		accOf[i] = -1
+		if opts.CutAccompaniment && opts.Accompaniment != nil && errs[i] == nil {
+			ext := opts.Output.Ext()
+			sp.Path = strings.TrimSuffix(sp.Path, ext) + ".accompaniment" + ext
+			accOf[i] = len(accSpans)
+			accSpans = append(accSpans, sp)
+		}
+	}
+	if len(accSpans) > 0 {
+		accErrs = cutSpans(ctx, opts.Accompaniment, accSpans, opts.Output)
+	}
+
+	var resultSegments []AudioWithTranscript
+	for i, seg := range kept {
+		span := spans[i]
+		if errs[i] != nil {
+			log.Printf("Error cutting segment (start: %.2f, end: %.2f): %v. Skipping.", seg.Start, seg.End, errs[i])
+			report.Rejected = append(report.Rejected, Rejection{
+				Index: idx[i], Start: seg.Start, End: seg.End, Text: seg.Text,
+				Filter: "cut", Reason: errs[i].Error(),
+			})
+			continue
+		}
+		absOutputPath := span.Path
+
+		// Measure what was actually written rather than trusting End-Start
+		info, err := Probe(ctx, absOutputPath)
+		if err != nil {
+			log.Printf("Error probing segment %s: %v. Skipping.", absOutputPath, err)
+			report.Rejected = append(report.Rejected, Rejection{
+				Index: idx[i], Start: seg.Start, End: seg.End, Text: seg.Text,
+				Filter: "probe", Reason: err.Error(),
+			})
+			continue
+		}
+
+		var lyric *LyricInfo
+		if lyrics != nil {
+			lyric = &lyrics[idx[i]]
+		}
+		source := audio.Source
+		source.Offset += secondsToDuration(span.Start)
+
+		var accompaniment *Audio
+		if j := accOf[i]; j >= 0 {
+			accompaniment = segmentAccompaniment(ctx, accSpans[j], accErrs[j], source)
+		}
+
 		// Append successful segment info to results
 		resultSegments = append(resultSegments, AudioWithTranscript{
 			Audio: Audio{
 				Path:     absOutputPath,
-				Duration: time.Duration(segmentDuration * float64(time.Second)),
-				Format:   outputSegmentFormat,
+				Duration: info.Duration,
+				Format:   info.Format,
+				Source:   source,
 			},
-			Text: seg.Text,
+			Text:           seg.Text,
+			NormalizedText: normalizer.Normalize(seg.Text),
+			Words:          rebaseWords(seg.Words, span.Start, info.Duration.Seconds()),
+			Lyrics:         lyric,
+			Metrics:        metrics[i],
+			Accompaniment:  accompaniment,
 		})
 	}
 
+	report.Kept = len(resultSegments)
+	if err := writeJSON(filepath.Join(outputDir, "report.json"), report); err != nil {
+		return nil, fmt.Errorf("write segment report: %w", err)
+	}
+
 	log.Printf("Successfully split audio into %d segments in %s", len(resultSegments), outputDir)
 	return resultSegments, nil
 }
+
+// segmentAccompaniment describes the accompaniment cut for a segment, or
+// returns nil after logging why it is missing; a segment is kept without it.
+func segmentAccompaniment(ctx context.Context, sp cutSpan, cutErr error, source Source) *Audio {
+	if cutErr != nil {
+		log.Printf("Warning: could not cut accompaniment %s: %v", sp.Path, cutErr)
+		return nil
+	}
+	info, err := Probe(ctx, sp.Path)
+	if err != nil {
+		log.Printf("Warning: could not probe accompaniment %s: %v", sp.Path, err)
+		return nil
+	}
+	return &Audio{Path: sp.Path, Duration: info.Duration, Format: info.Format, Source: source}
+}
+
+// wordCount is the number of aligned words in seg, or of whitespace
+// separated words in its text when whisperx could not align it.
+func wordCount(seg TranscriptSegment) int {
+	if len(seg.Words) > 0 {
+		return len(seg.Words)
+	}
+	return len(strings.Fields(seg.Text))
+}
+
+// segmentBounds pads segs[i] by the configured amounts without overlapping
+// its neighbours or running off the audio, and optionally snaps the padded
+// boundaries to nearby silence. A zero duration means the length of the
+// audio is unknown.
+func segmentBounds(segs []TranscriptSegment, i int, duration float64, opts SegmentOptions, env *envelope) (start, end float64) {
+	seg := segs[i]
+
+	lo, hi := 0.0, math.Inf(1)
+	if duration > 0 {
+		hi = duration
+	}
+	if i > 0 {
+		lo = max(lo, min(segs[i-1].End, seg.Start))
+	}
+	if i+1 < len(segs) {
+		hi = min(hi, max(segs[i+1].Start, seg.End))
+	}
+
+	start = max(seg.Start-opts.PrePaddingSeconds, lo)
+	end = min(seg.End+opts.PostPaddingSeconds, hi)
+
+	if env != nil {
+		w := opts.SnapWindowSeconds
+		start = env.quietest(max(start-w, lo), min(start+w, seg.Start), start)
+		end = env.quietest(max(end-w, seg.End), min(end+w, hi), end)
+	}
+	return start, end
+}
+
+// rebaseWords shifts word timings from the transcript's timeline onto that of
+// a segment cut at offset, clamping them to the segment's duration. Words
+// whisperx could not align are pinned to the end of the previous word.
+func rebaseWords(words []TimeAlignedWord, offset, duration float64) []TimeAlignedWord {
+	if len(words) == 0 {
+		return nil
+	}
+	clamp := func(t float64) float64 { return max(min(t-offset, duration), 0) }
+
+	out := make([]TimeAlignedWord, len(words))
+	prevEnd := 0.0
+	for i, w := range words {
+		if w.End <= w.Start {
+			w.Start, w.End = prevEnd, prevEnd
+		} else {
+			w.Start, w.End = clamp(w.Start), clamp(w.End)
+		}
+		prevEnd = w.End
+		out[i] = w
+	}
+	return out
+}
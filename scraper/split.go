@@ -0,0 +1,233 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SplitKey selects what segments are grouped by when splitting a dataset.
+// All segments of a group land in the same split, so a song or speaker never
+// leaks from training into evaluation.
+type SplitKey string
+
+const (
+	SplitByVideo   SplitKey = "video"
+	SplitByChannel SplitKey = "channel"
+)
+
+// DatasetSplit names one partition of a dataset.
+type DatasetSplit string
+
+const (
+	SplitTrain      DatasetSplit = "train"
+	SplitValidation DatasetSplit = "validation"
+	SplitTest       DatasetSplit = "test"
+)
+
+// datasetSplits is the fixed order splits are filled and written in.
+var datasetSplits = []DatasetSplit{SplitTrain, SplitValidation, SplitTest}
+
+// SplitOptions controls how SplitDataset partitions segments.
+type SplitOptions struct {
+	// By: Grouping key, "video" or "channel"; empty disables splitting
+	By SplitKey `json:"by"`
+	// Train, Validation, Test: Target shares of total audio duration; they are
+	// normalized, so 8/1/1 and 0.8/0.1/0.1 are the same
+	Train      float64 `json:"train"`
+	Validation float64 `json:"validation"`
+	Test       float64 `json:"test"`
+	// Seed: Salts the hash groups are assigned by; a group keeps its split for as long as the seed
+	// and ratios stay the same
+	Seed int64 `json:"seed"`
+}
+
+// DefaultSplitOptions is an 80/10/10 split by video, disabled until By is set.
+func DefaultSplitOptions() SplitOptions {
+	return SplitOptions{Train: 0.8, Validation: 0.1, Test: 0.1, Seed: 1}
+}
+
+func (o SplitOptions) ratio(s DatasetSplit) float64 {
+	switch s {
+	case SplitTrain:
+		return o.Train
+	case SplitValidation:
+		return o.Validation
+	case SplitTest:
+		return o.Test
+	}
+	return 0
+}
+
+// SplitStats counts what ended up in one split.
+type SplitStats struct {
+	Groups  int     `json:"groups"`
+	Items   int     `json:"items"`
+	Seconds float64 `json:"seconds"`
+	// Share is the split's fraction of the total duration.
+	Share float64 `json:"share"`
+}
+
+// SplitSummary describes a split; it is written next to the split manifests.
+type SplitSummary struct {
+	By     SplitKey                    `json:"by"`
+	Seed   int64                       `json:"seed"`
+	Splits map[DatasetSplit]SplitStats `json:"splits"`
+	// Groups maps every group key to the split it was assigned to.
+	Groups map[string]DatasetSplit `json:"groups"`
+}
+
+// splitGroupKey returns the group it belongs to. Segments without a video
+// ID fall back to the id in their YouTube URL, or the URL itself. A segment
+// without a channel can not be grouped by channel, as it would land in a
+// group apart from the rest of its channel; that is an error.
+func splitGroupKey(it AudioWithTranscript, by SplitKey) (string, error) {
+	if by == SplitByChannel {
+		if it.Source.Channel == "" {
+			return "", fmt.Errorf("%s has no channel to split by; process %s again to record it", it.Path, it.Source.URL)
+		}
+		return "channel:" + it.Source.Channel, nil
+	}
+	video := it.Source.VideoID
+	if video == "" {
+		video = youtubeID(it.Source.URL)
+	}
+	if video == "" {
+		video = it.Source.URL
+	}
+	return "video:" + video, nil
+}
+
+// splitFor assigns a group to a split by mapping a hash of the seed and key
+// onto the cumulative shares of the splits. total is the sum of the ratios.
+func (o SplitOptions) splitFor(key string, total float64) DatasetSplit {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(o.Seed, 10) + ":" + key))
+	u := float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53) // uniform in [0, 1)
+	var (
+		cum  float64
+		last DatasetSplit
+	)
+	for _, s := range datasetSplits {
+		r := o.ratio(s)
+		if r == 0 {
+			continue
+		}
+		cum += r / total
+		last = s
+		if u < cum {
+			return s
+		}
+	}
+	// Rounding can leave cum just short of 1.
+	return last
+}
+
+// SplitDataset partitions items into train, validation and test sets by
+// opts.By. Each group is assigned on its own from a hash of opts.Seed and
+// its key, so a group never changes split as sources are added and a split
+// once published does not leak into the next version of the dataset. The
+// shares of the total duration therefore only approach the ratios as the
+// number of groups grows. Items keep their input order within each split.
+func SplitDataset(items []AudioWithTranscript, opts SplitOptions) (map[DatasetSplit][]AudioWithTranscript, *SplitSummary, error) {
+	switch opts.By {
+	case SplitByVideo, SplitByChannel:
+	default:
+		return nil, nil, fmt.Errorf("unknown split key %q", opts.By)
+	}
+	var total float64
+	for _, s := range datasetSplits {
+		r := opts.ratio(s)
+		if r < 0 {
+			return nil, nil, fmt.Errorf("negative %s ratio %g", s, r)
+		}
+		total += r
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("split ratios are all zero")
+	}
+
+	summary := &SplitSummary{
+		By:     opts.By,
+		Seed:   opts.Seed,
+		Splits: make(map[DatasetSplit]SplitStats),
+		Groups: make(map[string]DatasetSplit),
+	}
+	var grand float64
+	keys := make([]string, len(items))
+	for i, it := range items {
+		key, err := splitGroupKey(it, opts.By)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[i] = key
+		if _, ok := summary.Groups[key]; !ok {
+			s := opts.splitFor(key, total)
+			summary.Groups[key] = s
+			st := summary.Splits[s]
+			st.Groups++
+			summary.Splits[s] = st
+		}
+		grand += it.Duration.Seconds()
+	}
+
+	wanted := 0
+	for _, s := range datasetSplits {
+		if opts.ratio(s) > 0 {
+			wanted++
+		}
+	}
+	if len(summary.Groups) < wanted {
+		log.Printf("Warning: only %d %s groups for %d splits; some splits will be empty until more sources are added",
+			len(summary.Groups), opts.By, wanted)
+	}
+
+	out := make(map[DatasetSplit][]AudioWithTranscript)
+	for i, it := range items {
+		s := summary.Groups[keys[i]]
+		out[s] = append(out[s], it)
+		st := summary.Splits[s]
+		st.Items++
+		st.Seconds += it.Duration.Seconds()
+		summary.Splits[s] = st
+	}
+	for s, st := range summary.Splits {
+		if grand > 0 {
+			st.Share = st.Seconds / grand
+		}
+		summary.Splits[s] = st
+	}
+	return out, summary, nil
+}
+
+// WriteSplits splits items with SplitDataset and writes one manifest per
+// split and format to dir, named after the format's FileName with the split
+// inserted before the extension (manifest.train.jsonl, metadata.test.csv),
+// plus the summary as split.json. Every split gets a manifest, even if empty.
+func WriteSplits(dir string, formats []ManifestFormat, items []AudioWithTranscript, opts SplitOptions) (*SplitSummary, error) {
+	splits, summary, err := SplitDataset(items, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("mkdir manifest dir: %w", err)
+	}
+	for _, s := range datasetSplits {
+		for _, f := range formats {
+			name := f.FileName()
+			ext := filepath.Ext(name)
+			name = strings.TrimSuffix(name, ext) + "." + string(s) + ext
+			if err := WriteManifest(filepath.Join(dir, name), f, splits[s]); err != nil {
+				return nil, fmt.Errorf("%s split: %w", s, err)
+			}
+		}
+	}
+	if err := writeJSON(filepath.Join(dir, "split.json"), summary); err != nil {
+		return nil, fmt.Errorf("write split summary: %w", err)
+	}
+	return summary, nil
+}
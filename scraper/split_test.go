@@ -0,0 +1,191 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// splitItems makes segs segments of one second for each of videos videos,
+// with video i uploaded by channel i%channels.
+func splitItems(videos, segs, channels int) []AudioWithTranscript {
+	var items []AudioWithTranscript
+	for v := 0; v < videos; v++ {
+		for s := 0; s < segs; s++ {
+			items = append(items, AudioWithTranscript{Audio: Audio{
+				Path:     fmt.Sprintf("v%d_s%d.wav", v, s),
+				Duration: time.Second,
+				Source:   Source{VideoID: fmt.Sprintf("v%d", v), Channel: fmt.Sprintf("c%d", v%channels)},
+			}})
+		}
+	}
+	return items
+}
+
+func TestSplitDataset(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []AudioWithTranscript
+		opts  SplitOptions
+		// wantGroups, when set, is the exact number of groups in each split.
+		wantGroups map[DatasetSplit]int
+		// wantShares, when set, are the expected shares of the duration,
+		// give or take 0.03.
+		wantShares map[DatasetSplit]float64
+		wantErr    bool
+	}{
+		{
+			name:       "80/10/10 by video",
+			items:      splitItems(2000, 1, 2000),
+			opts:       SplitOptions{By: SplitByVideo, Train: 0.8, Validation: 0.1, Test: 0.1, Seed: 1},
+			wantShares: map[DatasetSplit]float64{SplitTrain: 0.8, SplitValidation: 0.1, SplitTest: 0.1},
+		},
+		{
+			name:       "ratios are normalized",
+			items:      splitItems(2000, 1, 2000),
+			opts:       SplitOptions{By: SplitByVideo, Train: 8, Validation: 1, Test: 1, Seed: 7},
+			wantShares: map[DatasetSplit]float64{SplitTrain: 0.8, SplitValidation: 0.1, SplitTest: 0.1},
+		},
+		{
+			name:       "by channel",
+			items:      splitItems(4000, 1, 2000),
+			opts:       SplitOptions{By: SplitByChannel, Train: 0.6, Validation: 0.2, Test: 0.2, Seed: 3},
+			wantShares: map[DatasetSplit]float64{SplitTrain: 0.6, SplitValidation: 0.2, SplitTest: 0.2},
+		},
+		{
+			name:       "zero ratio splits stay empty",
+			items:      splitItems(5, 1, 5),
+			opts:       SplitOptions{By: SplitByVideo, Validation: 1, Seed: 1},
+			wantGroups: map[DatasetSplit]int{SplitValidation: 5},
+		},
+		{
+			name:  "segments of a channel stay together",
+			items: splitItems(6, 2, 1),
+			opts:  SplitOptions{By: SplitByChannel, Train: 0.5, Validation: 0.25, Test: 0.25, Seed: 1},
+		},
+		{
+			name: "a missing channel is an error",
+			items: append(splitItems(2, 1, 1), AudioWithTranscript{Audio: Audio{
+				Path: "old.wav", Duration: time.Second, Source: Source{URL: "https://youtu.be/abc"},
+			}}),
+			opts:    SplitOptions{By: SplitByChannel, Train: 1},
+			wantErr: true,
+		},
+		{name: "unknown key", items: splitItems(2, 1, 1), opts: SplitOptions{By: "speaker", Train: 1}, wantErr: true},
+		{name: "negative ratio", items: splitItems(2, 1, 1), opts: SplitOptions{By: SplitByVideo, Train: 1, Test: -0.1}, wantErr: true},
+		{name: "all ratios zero", items: splitItems(2, 1, 1), opts: SplitOptions{By: SplitByVideo}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			splits, summary, err := SplitDataset(tt.items, tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("SplitDataset succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SplitDataset: %v", err)
+			}
+
+			total, groups := 0, 0
+			for _, s := range datasetSplits {
+				if got := summary.Splits[s].Groups; tt.wantGroups != nil && got != tt.wantGroups[s] {
+					t.Errorf("%s has %d groups, want %d", s, got, tt.wantGroups[s])
+				}
+				if got, want := summary.Splits[s].Share, tt.wantShares[s]; tt.wantShares != nil && math.Abs(got-want) > 0.03 {
+					t.Errorf("%s has %.3f of the audio, want %.3f", s, got, want)
+				}
+				if got := summary.Splits[s].Items; got != len(splits[s]) {
+					t.Errorf("%s summary counts %d items, split has %d", s, got, len(splits[s]))
+				}
+				for _, it := range splits[s] {
+					key, _ := splitGroupKey(it, tt.opts.By)
+					if g := summary.Groups[key]; g != s {
+						t.Errorf("%s is in %s but its group is assigned to %s", it.Path, s, g)
+					}
+				}
+				total += len(splits[s])
+				groups += summary.Splits[s].Groups
+			}
+			if total != len(tt.items) {
+				t.Errorf("splits hold %d items, want %d", total, len(tt.items))
+			}
+			if groups != len(summary.Groups) {
+				t.Errorf("splits count %d groups, summary has %d", groups, len(summary.Groups))
+			}
+		})
+	}
+}
+
+func TestSplitDatasetKeepsAssignmentsAsGroupsAreAdded(t *testing.T) {
+	opts := SplitOptions{By: SplitByVideo, Train: 0.8, Validation: 0.1, Test: 0.1, Seed: 5}
+	items := splitItems(50, 2, 50)
+
+	_, before, err := SplitDataset(items[:40], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{42, 60, len(items)} {
+		_, after, err := SplitDataset(items[:n], opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, s := range before.Groups {
+			if after.Groups[key] != s {
+				t.Errorf("with %d items group %s moved from %s to %s", n, key, s, after.Groups[key])
+			}
+		}
+	}
+}
+
+func TestSplitDatasetIgnoresItemOrder(t *testing.T) {
+	items := splitItems(12, 2, 12)
+	reversed := make([]AudioWithTranscript, len(items))
+	for i, it := range items {
+		reversed[len(items)-1-i] = it
+	}
+	opts := SplitOptions{By: SplitByVideo, Train: 0.5, Validation: 0.25, Test: 0.25, Seed: 42}
+
+	_, a, err := SplitDataset(items, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := SplitDataset(reversed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, s := range a.Groups {
+		if b.Groups[key] != s {
+			t.Errorf("group %s went to %s, and to %s with the items reversed", key, s, b.Groups[key])
+		}
+	}
+}
+
+func TestSplitGroupKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  Source
+		by      SplitKey
+		want    string
+		wantErr bool
+	}{
+		{name: "video id", source: Source{URL: "https://youtu.be/abc", VideoID: "abc"}, by: SplitByVideo, want: "video:abc"},
+		{name: "id from a YouTube URL", source: Source{URL: "https://www.youtube.com/watch?v=abc"}, by: SplitByVideo, want: "video:abc"},
+		{name: "other URLs", source: Source{URL: "https://example.com/a.mp3"}, by: SplitByVideo, want: "video:https://example.com/a.mp3"},
+		{name: "channel", source: Source{URL: "https://youtu.be/abc", VideoID: "abc", Channel: "UC1"}, by: SplitByChannel, want: "channel:UC1"},
+		{name: "no channel", source: Source{URL: "https://youtu.be/abc", VideoID: "abc"}, by: SplitByChannel, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitGroupKey(AudioWithTranscript{Audio: Audio{Path: "a.wav", Source: tt.source}}, tt.by)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitGroupKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("splitGroupKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SubtitleFormat is a subtitle or synced lyrics file format.
+type SubtitleFormat string
+
+const (
+	SubtitleSRT SubtitleFormat = "srt"
+	SubtitleVTT SubtitleFormat = "vtt"
+	SubtitleLRC SubtitleFormat = "lrc"
+)
+
+// ExportSubtitles writes tat as base.srt, base.vtt and base.lrc and returns
+// the paths it wrote.
+func ExportSubtitles(tat *TimeAlignedTranscript, base string) ([]string, error) {
+	var paths []string
+	for _, format := range []SubtitleFormat{SubtitleSRT, SubtitleVTT, SubtitleLRC} {
+		path := base + "." + string(format)
+		if err := writeSubtitleFile(path, format, tat); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeSubtitleFile(path string, format SubtitleFormat, tat *TimeAlignedTranscript) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", format, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	switch format {
+	case SubtitleSRT:
+		err = WriteSRT(w, tat)
+	case SubtitleVTT:
+		err = WriteVTT(w, tat)
+	case SubtitleLRC:
+		err = WriteLRC(w, tat)
+	default:
+		err = fmt.Errorf("unknown subtitle format %q", format)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", format, err)
+	}
+	return f.Close()
+}
+
+// WriteSRT renders one SubRip cue per transcript segment.
+func WriteSRT(w io.Writer, tat *TimeAlignedTranscript) error {
+	n := 0
+	for _, seg := range tat.Segments {
+		text := oneLine(seg.Text)
+		if text == "" {
+			continue
+		}
+		n++
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n",
+			n, subtitleTimestamp(seg.Start, ","), subtitleTimestamp(seg.End, ","), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteVTT renders one WebVTT cue per transcript segment. Aligned words get
+// inline timestamp tags so players can highlight them karaoke style.
+func WriteVTT(w io.Writer, tat *TimeAlignedTranscript) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for _, seg := range tat.Segments {
+		text := vttCueText(seg)
+		if text == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n",
+			subtitleTimestamp(seg.Start, "."), subtitleTimestamp(seg.End, "."), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vttCueText joins the words of seg, tagging every word after the first
+// with its start time. Timestamps must lie strictly inside the cue, so words
+// without alignment or outside the segment are left untagged.
+func vttCueText(seg TranscriptSegment) string {
+	if len(seg.Words) == 0 {
+		return vttEscape(oneLine(seg.Text))
+	}
+	parts := make([]string, 0, len(seg.Words))
+	for i, wd := range seg.Words {
+		word := vttEscape(strings.TrimSpace(wd.Word))
+		if word == "" {
+			continue
+		}
+		if i > 0 && wd.End > wd.Start && wd.Start > seg.Start && wd.Start < seg.End {
+			word = "<" + subtitleTimestamp(wd.Start, ".") + ">" + word
+		}
+		parts = append(parts, word)
+	}
+	return strings.Join(parts, " ")
+}
+
+func vttEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// WriteLRC renders tat as an LRC synced lyrics file with one timed line per
+// segment.
+func WriteLRC(w io.Writer, tat *TimeAlignedTranscript) error {
+	if tat.Language != "" {
+		if _, err := fmt.Fprintf(w, "[la:%s]\n", tat.Language); err != nil {
+			return err
+		}
+	}
+	for _, seg := range tat.Segments {
+		text := oneLine(seg.Text)
+		if text == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "[%s]%s\n", lrcTimestamp(seg.Start), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtitleTimestamp formats seconds as HH:MM:SS<sep>mmm; SRT uses a comma
+// before the milliseconds and WebVTT a dot.
+func subtitleTimestamp(sec float64, sep string) string {
+	ms := int64(max(sec, 0)*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// lrcTimestamp formats seconds as mm:ss.xx.
+func lrcTimestamp(sec float64) string {
+	cs := int64(max(sec, 0)*100 + 0.5)
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
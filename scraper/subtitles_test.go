@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSubtitles(t *testing.T) {
+	tat := &TimeAlignedTranscript{Language: "en", Segments: []TranscriptSegment{
+		{Text: " Humpty Dumpty sat ", Start: 1.5, End: 3.25, Words: []TimeAlignedWord{
+			{Start: 1.5, End: 2, Word: "Humpty"},
+			{Start: 2.1, End: 2.6, Word: "Dumpty"},
+			{Start: 2.7, End: 3.25, Word: "sat"},
+		}},
+		{Text: "   ", Start: 4, End: 5},
+		{Text: "on a <wall> & fell", Start: 3725.0004, End: 3727.9996, Words: []TimeAlignedWord{
+			{Start: 3725.0004, End: 3725.5, Word: "on"},
+			{Word: "a"},
+			{Start: 3725.8, End: 3726.2, Word: "<wall>"},
+			{Start: 3726.3, End: 3726.5, Word: "&"},
+			{Start: 3728.5, End: 3729, Word: "fell"},
+		}},
+		{Text: "all the king's horses", Start: 3730, End: 3732},
+	}}
+
+	tests := []struct {
+		name  string
+		write func(io.Writer, *TimeAlignedTranscript) error
+		want  string
+	}{
+		{
+			name:  "srt",
+			write: WriteSRT,
+			want: "1\n00:00:01,500 --> 00:00:03,250\nHumpty Dumpty sat\n\n" +
+				"2\n01:02:05,000 --> 01:02:08,000\non a <wall> & fell\n\n" +
+				"3\n01:02:10,000 --> 01:02:12,000\nall the king's horses\n\n",
+		},
+		{
+			name:  "vtt",
+			write: WriteVTT,
+			want: "WEBVTT\n\n" +
+				"00:00:01.500 --> 00:00:03.250\nHumpty <00:00:02.100>Dumpty <00:00:02.700>sat\n\n" +
+				"01:02:05.000 --> 01:02:08.000\non a <01:02:05.800>&lt;wall&gt; <01:02:06.300>&amp; fell\n\n" +
+				"01:02:10.000 --> 01:02:12.000\nall the king's horses\n\n",
+		},
+		{
+			name:  "lrc",
+			write: WriteLRC,
+			want:  "[la:en]\n[00:01.50]Humpty Dumpty sat\n[62:05.00]on a <wall> & fell\n[62:10.00]all the king's horses\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tt.write(&b, tat); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtitleTimestamps(t *testing.T) {
+	tests := []struct {
+		sec      float64
+		srt, lrc string
+	}{
+		{0, "00:00:00,000", "00:00.00"},
+		{-1, "00:00:00,000", "00:00.00"},
+		{59.9996, "00:01:00,000", "01:00.00"},
+		{61.234, "00:01:01,234", "01:01.23"},
+		{3600.5, "01:00:00,500", "60:00.50"},
+	}
+	for _, tt := range tests {
+		if got := subtitleTimestamp(tt.sec, ","); got != tt.srt {
+			t.Errorf("subtitleTimestamp(%v) = %q, want %q", tt.sec, got, tt.srt)
+		}
+		if got := lrcTimestamp(tt.sec); got != tt.lrc {
+			t.Errorf("lrcTimestamp(%v) = %q, want %q", tt.sec, got, tt.lrc)
+		}
+	}
+}
+
+func TestExportSubtitles(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "vocals.aligned")
+	tat := &TimeAlignedTranscript{Segments: []TranscriptSegment{{Text: "Jack and Jill", Start: 0, End: 1}}}
+	paths, err := ExportSubtitles(tat, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{base + ".srt", base + ".vtt", base + ".lrc"}
+	if len(paths) != len(want) {
+		t.Fatalf("ExportSubtitles wrote %v, want %v", paths, want)
+	}
+	for i, path := range paths {
+		if path != want[i] {
+			t.Errorf("path %d = %s, want %s", i, path, want[i])
+		}
+		if data, err := os.ReadFile(path); err != nil || !bytes.Contains(data, []byte("Jack and Jill")) {
+			t.Errorf("%s: %q, %v", path, data, err)
+		}
+	}
+}
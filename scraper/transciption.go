@@ -1,12 +1,14 @@
 package scraper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 type TimeAlignedWord struct {
@@ -25,36 +27,135 @@ type TranscriptSegment struct {
 
 type TimeAlignedTranscript struct {
 	Segments []TranscriptSegment `json:"segments"`
+	Language string              `json:"language"`
 }
 
-func Transcribe(vocals *Audio, artifactsDir string) (*TimeAlignedTranscript, error) {
+// TranscribeOptions controls how Transcribe runs whisperx.
+type TranscribeOptions struct {
+	// ChunkSeconds: Transcribe audio longer than this in chunks split on silence; 0 transcribes in one go
+	ChunkSeconds float64 `json:"chunk_seconds"`
+	// SearchSeconds: How far from each nominal chunk boundary to look for the quietest point to cut at
+	SearchSeconds float64 `json:"search_seconds"`
+	// OverlapSeconds: Extra audio given to each chunk on both sides so words at a cut are not lost
+	OverlapSeconds float64 `json:"overlap_seconds"`
+	// Workers: Chunks transcribed at the same time
+	Workers int `json:"workers"`
+	// Speech: Speech regions detected in the vocals; when set only they are transcribed, joined by
+	// SpeechGapSeconds of silence, and the transcript is moved back onto the original timeline
+	Speech           *SpeechActivity `json:"-"`
+	SpeechGapSeconds float64         `json:"speech_gap_seconds"`
+}
+
+// DefaultTranscribeOptions transcribes in one go; setting ChunkSeconds
+// enables chunking with these margins.
+func DefaultTranscribeOptions() TranscribeOptions {
+	return TranscribeOptions{SearchSeconds: 10, OverlapSeconds: 1, Workers: 1, SpeechGapSeconds: 0.5}
+}
+
+// Transcribe runs whisperx on vocals and returns the aligned transcript,
+// which is also written to TranscriptPath(vocals, artifactsDir). With
+// opts.ChunkSeconds set, long audio is cut on silence into chunks that are
+// transcribed concurrently and merged back onto the timeline of vocals.
+// With opts.Speech set, whisperx only gets to hear the speech regions.
+func Transcribe(ctx context.Context, vocals *Audio, artifactsDir string, opts TranscribeOptions) (*TimeAlignedTranscript, error) {
 	if !filepath.IsAbs(vocals.Path) {
 		return nil, fmt.Errorf("vocals path: %s has to be absolute path", vocals.Path)
 	}
 
-	cmd := exec.Command("whisperx",
-		vocals.Path,
+	if opts.Speech != nil {
+		tat, err := transcribeSpeech(ctx, vocals, artifactsDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeJSON(TranscriptPath(vocals, artifactsDir), tat); err != nil {
+			return nil, fmt.Errorf("write transcript: %w", err)
+		}
+		return tat, nil
+	}
+
+	if opts.ChunkSeconds > 0 {
+		chunks, err := planTranscribeChunks(ctx, vocals, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunks) > 1 {
+			tat, err := transcribeChunked(ctx, vocals, chunks, artifactsDir, opts)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeJSON(TranscriptPath(vocals, artifactsDir), tat); err != nil {
+				return nil, fmt.Errorf("write transcript: %w", err)
+			}
+			return tat, nil
+		}
+	}
+
+	if err := runWhisperX(ctx, vocals.Path, artifactsDir); err != nil {
+		return nil, err
+	}
+	return ReadTranscript(TranscriptPath(vocals, artifactsDir))
+}
+
+// transcribeSpeech transcribes the speech regions of vocals, joined into
+// <vocals>.trimmed.wav, and maps the transcript back onto vocals. Without
+// any speech there is nothing for whisperx to hear and the transcript is
+// empty.
+func transcribeSpeech(ctx context.Context, vocals *Audio, artifactsDir string, opts TranscribeOptions) (*TimeAlignedTranscript, error) {
+	activity := opts.Speech
+	if len(activity.Regions) == 0 {
+		return &TimeAlignedTranscript{}, nil
+	}
+	gap := max(opts.SpeechGapSeconds, 0)
+	base := strings.TrimSuffix(vocals.Path, filepath.Ext(vocals.Path))
+	trimmed := &Audio{Path: base + ".trimmed.wav", Format: FormatWAV, Source: vocals.Source}
+	if err := trimSilence(ctx, vocals, activity, gap, trimmed.Path); err != nil {
+		return nil, fmt.Errorf("trim silence: %w", err)
+	}
+	log.Printf("transcribing %.1fs of speech out of %.1fs", activity.SpeechSeconds(), activity.Duration)
+
+	opts.Speech = nil
+	tat, err := Transcribe(ctx, trimmed, artifactsDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	untrimTranscript(tat, activity, gap)
+	return tat, nil
+}
+
+// runWhisperX transcribes path, writing its outputs to dir.
+func runWhisperX(ctx context.Context, path, dir string) error {
+	cmd := exec.CommandContext(ctx, "whisperx",
+		path,
 		"--model", "large-v3",
 		"--align_model", "WAV2VEC2_ASR_LARGE_LV60K_960H",
 		"--batch_size", "4",
 	)
-	cmd.Dir = artifactsDir
+	cmd.Dir = dir
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Run()
-	if err != nil {
-		log.Fatalf("Failed to run whisperx: %v", err)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("whisperx %s: %w", filepath.Base(path), err)
 	}
+	return nil
+}
 
-	jsonData, err := os.ReadFile(filepath.Join(artifactsDir, "vocals.json"))
+// TranscriptPath is where whisperx writes the JSON transcript of audio when
+// run inside artifactsDir.
+func TranscriptPath(audio *Audio, artifactsDir string) string {
+	base := filepath.Base(audio.Path)
+	return filepath.Join(artifactsDir, strings.TrimSuffix(base, filepath.Ext(base))+".json")
+}
+
+// ReadTranscript loads a whisperx JSON transcript from path.
+func ReadTranscript(path string) (*TimeAlignedTranscript, error) {
+	jsonData, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to read vocals.json: %v", err)
+		return nil, fmt.Errorf("read transcript: %w", err)
 	}
 
 	timeAlignedTranscript := &TimeAlignedTranscript{}
 	if err := json.Unmarshal(jsonData, timeAlignedTranscript); err != nil {
-		log.Fatalf("Failed to unmarshal vocals.json: %v", err)
+		return nil, fmt.Errorf("unmarshal transcript: %w", err)
 	}
-
 	return timeAlignedTranscript, nil
 }
@@ -5,18 +5,48 @@ import "time"
 type Format string
 
 const (
-	FormatMP3 Format = "mp3"
-	FormatM4A Format = "m4a"
-	FormatWAV Format = "wav"
+	FormatMP3  Format = "mp3"
+	FormatM4A  Format = "m4a"
+	FormatWAV  Format = "wav"
+	FormatFLAC Format = "flac"
+	FormatOpus Format = "opus"
 )
 
+// Source records where an Audio came from so that timestamps inside it can
+// be mapped back onto the original video.
+type Source struct {
+	URL string `json:"url,omitempty"`
+	// VideoID and Channel identify the source video and its uploader, when
+	// known; dataset splits group segments by them.
+	VideoID string `json:"video_id,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	// Offset is the position of the start of the Audio within the source video.
+	Offset time.Duration `json:"offset"`
+	// Chapter is the title of the video chapter the Audio belongs to, if any.
+	Chapter string `json:"chapter,omitempty"`
+}
+
 type Audio struct {
 	Path     string        `json:"path"`
 	Duration time.Duration `json:"duration"`
 	Format   Format        `json:"format"`
+	Source   Source        `json:"source"`
 }
 
 type AudioWithTranscript struct {
 	Audio
+	// Text is the transcript exactly as whisperx produced it.
 	Text string `json:"text"`
+	// NormalizedText is Text after SegmentOptions.Text normalization.
+	NormalizedText string `json:"normalized_text"`
+	// Words are the aligned words of Text, timed relative to the start of
+	// the segment's own audio.
+	Words []TimeAlignedWord `json:"words,omitempty"`
+	// Lyrics is only set when segmenting in SegmentModeLyrics.
+	Lyrics *LyricInfo `json:"lyrics,omitempty"`
+	// Metrics are only set when SegmentOptions.Metrics is.
+	Metrics *SegmentMetrics `json:"metrics,omitempty"`
+	// Accompaniment is the same span cut from the accompaniment stem, when
+	// SegmentOptions.CutAccompaniment is set.
+	Accompaniment *Audio `json:"accompaniment,omitempty"`
 }
@@ -1,6 +1,11 @@
 package scraper
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -8,14 +13,133 @@ import (
 	"github.com/tcolgate/mp3"
 )
 
+const (
+	// mp3EstimateSampleFrames: Number of leading frames Mp3DurationEstimate inspects to decide whether a file is CBR
+	mp3EstimateSampleFrames = 64
+	// mp3EstimateErrorFrames: Worst-case error of a CBR estimate, in frames
+	mp3EstimateErrorFrames = 2
+)
+
+// Mp3Duration returns the playing time of an MP3 file. It trusts a Xing,
+// Info or VBRI header in the first frame when one carries a frame count, and
+// only falls back to decoding every frame when none does.
+func Mp3Duration(path string) (time.Duration, error) {
+	f, err := openMP3(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if dur, ok := f.headerDuration(); ok {
+		return dur, nil
+	}
+	return f.scan()
+}
+
+// Mp3DurationEstimate is a cheaper alternative to Mp3Duration for files
+// without a Xing/Info/VBRI header. If the first frames all share one bit rate
+// the file is assumed to be CBR and its duration is derived from the size of
+// the audio payload; maxErr bounds how far that estimate can be off. VBR
+// files, and files with a header, are measured exactly and report maxErr 0.
+func Mp3DurationEstimate(path string) (dur, maxErr time.Duration, err error) {
+	f, err := openMP3(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	if dur, ok := f.headerDuration(); ok {
+		return dur, 0, nil
+	}
+
+	bitRate, frameDur, cbr, err := f.sniffCBR()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !cbr {
+		dur, err := f.scan()
+		return dur, 0, err
+	}
+
+	payload := f.end - f.firstFrame
+	dur = time.Duration(float64(payload*8) / float64(bitRate) * float64(time.Second))
+	return dur, mp3EstimateErrorFrames * frameDur, nil
+}
+
+// Mp3DurationByFrames decodes every frame of the file and sums their
+// durations. ID3v2, ID3v1 and APE tags are skipped so their contents can not
+// be mistaken for frames.
 func Mp3DurationByFrames(path string) (time.Duration, error) {
-	f, err := os.Open(path)
+	f, err := openMP3(path)
 	if err != nil {
 		return 0, err
 	}
 	defer f.Close()
 
-	d := mp3.NewDecoder(f)
+	return f.scan()
+}
+
+// mp3File is an open MP3 with the byte range holding audio frames located.
+type mp3File struct {
+	*os.File
+	// firstFrame is the offset of the first frame header, end is the offset
+	// just past the last byte that can belong to a frame.
+	firstFrame int64
+	end        int64
+	first      mp3.Frame
+}
+
+func openMP3(path string) (*mp3File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &mp3File{File: f}
+	if err := m.locate(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// locate finds the audio payload between leading ID3v2 and trailing
+// ID3v1/APEv2 tags and decodes the first frame.
+func (m *mp3File) locate() error {
+	st, err := m.Stat()
+	if err != nil {
+		return err
+	}
+	end, err := trailingTagsStart(m.File, st.Size())
+	if err != nil {
+		return err
+	}
+
+	if err := skipID3v2(m.File); err != nil {
+		return fmt.Errorf("skip id3v2: %w", err)
+	}
+	start, err := m.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		return errors.New("no audio frames")
+	}
+
+	var skipped int
+	if err := mp3.NewDecoder(io.LimitReader(m.File, end-start)).Decode(&m.first, &skipped); err != nil {
+		return fmt.Errorf("decode first frame: %w", err)
+	}
+	m.firstFrame = start + int64(skipped)
+	m.end = end
+	return nil
+}
+
+// scan decodes every frame from the first one on and sums their durations.
+func (m *mp3File) scan() (time.Duration, error) {
+	if _, err := m.Seek(m.firstFrame, io.SeekStart); err != nil {
+		return 0, err
+	}
+	d := mp3.NewDecoder(io.LimitReader(m.File, m.end-m.firstFrame))
 	var (
 		frame   mp3.Frame
 		skipped int
@@ -23,7 +147,8 @@ func Mp3DurationByFrames(path string) (time.Duration, error) {
 	)
 	for {
 		if err := d.Decode(&frame, &skipped); err != nil {
-			if err == io.EOF {
+			// A truncated final frame is as good as the end of the file.
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				break
 			}
 			return 0, err
@@ -32,3 +157,149 @@ func Mp3DurationByFrames(path string) (time.Duration, error) {
 	}
 	return total, nil
 }
+
+// sniffCBR decodes the first mp3EstimateSampleFrames frames and reports
+// whether they all use the same bit rate.
+func (m *mp3File) sniffCBR() (bitRate int, frameDur time.Duration, cbr bool, err error) {
+	if _, err := m.Seek(m.firstFrame, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+	d := mp3.NewDecoder(io.LimitReader(m.File, m.end-m.firstFrame))
+	var (
+		frame   mp3.Frame
+		skipped int
+	)
+	for n := 0; n < mp3EstimateSampleFrames; n++ {
+		if err := d.Decode(&frame, &skipped); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, 0, false, err
+		}
+		br := int(frame.Header().BitRate())
+		if n == 0 {
+			bitRate, frameDur = br, frame.Duration()
+		} else if br != bitRate {
+			return 0, 0, false, nil
+		}
+	}
+	return bitRate, frameDur, bitRate > 0, nil
+}
+
+// headerDuration reads the frame count from a Xing/Info or VBRI header in the
+// first frame.
+func (m *mp3File) headerDuration() (time.Duration, bool) {
+	frames, ok := vbrFrameCount(&m.first)
+	if !ok || frames == 0 {
+		return 0, false
+	}
+	h := m.first.Header()
+	samples := int64(frames) * int64(m.first.Samples())
+	return time.Duration(samples) * time.Second / time.Duration(h.SampleRate()), true
+}
+
+// vbrFrameCount extracts the total number of frames from a Xing/Info header,
+// which sits right after the side information, or a VBRI header, which sits
+// 32 bytes after the frame header.
+func vbrFrameCount(frame *mp3.Frame) (uint32, bool) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(frame.Reader()); err != nil {
+		return 0, false
+	}
+	b := buf.Bytes()
+
+	sideInfo, err := frame.SideInfoLength()
+	if err != nil {
+		return 0, false
+	}
+	xing := 4 + sideInfo
+	if frame.Header().Protection() {
+		xing += 2
+	}
+	if len(b) >= xing+12 {
+		tag := string(b[xing : xing+4])
+		flags := binary.BigEndian.Uint32(b[xing+4 : xing+8])
+		if (tag == "Xing" || tag == "Info") && flags&0x1 != 0 {
+			return binary.BigEndian.Uint32(b[xing+8 : xing+12]), true
+		}
+	}
+
+	const vbri = 4 + 32
+	if len(b) >= vbri+18 && string(b[vbri:vbri+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(b[vbri+14 : vbri+18]), true
+	}
+	return 0, false
+}
+
+// trailingTagsStart returns the offset at which ID3v1 and APEv2 tags at the
+// end of an MP3 begin, or size if there are none.
+func trailingTagsStart(r io.ReaderAt, size int64) (int64, error) {
+	end := size
+
+	if end >= 128 {
+		var tag [3]byte
+		if _, err := r.ReadAt(tag[:], end-128); err != nil {
+			return 0, fmt.Errorf("read id3v1: %w", err)
+		}
+		if string(tag[:]) == "TAG" {
+			end -= 128
+		}
+	}
+
+	// APEv2 footer: "APETAGEX", version, size (footer and items, not the
+	// optional header), item count, flags.
+	if end >= 32 {
+		var footer [32]byte
+		if _, err := r.ReadAt(footer[:], end-32); err != nil {
+			return 0, fmt.Errorf("read ape footer: %w", err)
+		}
+		if string(footer[0:8]) == "APETAGEX" {
+			tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+			flags := binary.LittleEndian.Uint32(footer[20:24])
+			if flags&(1<<31) != 0 {
+				tagSize += 32
+			}
+			if tagSize <= end {
+				end -= tagSize
+			}
+		}
+	}
+	return end, nil
+}
+
+// skipID3v2 advances r past an ID3v2 tag if one starts at the current
+// position, and leaves r untouched otherwise.
+func skipID3v2(r io.ReadSeeker) error {
+	var hdr [10]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read id3 header: %w", err)
+	}
+	if n < len(hdr) || string(hdr[0:3]) != "ID3" {
+		_, err := r.Seek(-int64(n), io.SeekCurrent)
+		return err
+	}
+	// Tag size is a 28-bit syncsafe integer, excluding the 10 byte header and
+	// the optional 10 byte footer.
+	size := int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9])
+	if hdr[5]&0x10 != 0 {
+		size += 10
+	}
+	_, err = r.Seek(size, io.SeekCurrent)
+	return err
+}
+
+// secondsToDuration converts a float number of seconds, as used by yt-dlp
+// and whisperx, into a time.Duration.
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// writeJSON writes v to path as indented JSON.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
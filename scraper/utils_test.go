@@ -0,0 +1,176 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp3FrameLen is the length of an MPEG-1 Layer III frame at 128 kbps and
+// 44.1 kHz without padding.
+const mp3FrameLen = 144 * 128000 / 44100
+
+// mp3Frame returns one silent 128 kbps, 44.1 kHz stereo frame. A non-empty
+// tag is written where a Xing/Info header goes, or where a VBRI header goes
+// if it is "VBRI", with frames as the frame count.
+func mp3Frame(tag string, frames uint32) []byte {
+	f := make([]byte, mp3FrameLen)
+	copy(f, []byte{0xFF, 0xFB, 0x90, 0x00})
+	switch tag {
+	case "":
+	case "VBRI":
+		copy(f[36:], "VBRI")
+		binary.BigEndian.PutUint32(f[36+14:], frames)
+	default:
+		// Side information of a stereo MPEG-1 frame is 32 bytes.
+		copy(f[36:], tag)
+		binary.BigEndian.PutUint32(f[40:], 0x1)
+		binary.BigEndian.PutUint32(f[44:], frames)
+	}
+	return f
+}
+
+func mp3Frames(n int) time.Duration {
+	return time.Duration(n) * 1152 * time.Second / 44100
+}
+
+func TestMp3Duration(t *testing.T) {
+	id3v2 := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 100}, make([]byte, 100)...)
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	ape := func() []byte {
+		footer := make([]byte, 32)
+		copy(footer, "APETAGEX")
+		binary.LittleEndian.PutUint32(footer[12:], 32+16) // footer plus items
+		return append(make([]byte, 16), footer...)
+	}()
+
+	tests := []struct {
+		name  string
+		parts [][]byte
+		want  time.Duration
+	}{
+		{
+			name:  "frames only",
+			parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 10)},
+			want:  mp3Frames(10),
+		},
+		{
+			name:  "id3v2 and id3v1 tags are skipped",
+			parts: [][]byte{id3v2, bytes.Repeat(mp3Frame("", 0), 7), id3v1},
+			want:  mp3Frames(7),
+		},
+		{
+			name:  "ape tag is skipped",
+			parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 5), ape},
+			want:  mp3Frames(5),
+		},
+		{
+			name:  "xing frame count is trusted",
+			parts: [][]byte{mp3Frame("Xing", 1000), bytes.Repeat(mp3Frame("", 0), 3)},
+			want:  mp3Frames(1000),
+		},
+		{
+			name:  "info frame count is trusted",
+			parts: [][]byte{id3v2, mp3Frame("Info", 250), bytes.Repeat(mp3Frame("", 0), 3)},
+			want:  mp3Frames(250),
+		},
+		{
+			name:  "vbri frame count is trusted",
+			parts: [][]byte{mp3Frame("VBRI", 500), bytes.Repeat(mp3Frame("", 0), 3)},
+			want:  mp3Frames(500),
+		},
+		{
+			name:  "zero xing frame count falls back to scanning",
+			parts: [][]byte{mp3Frame("Xing", 0), bytes.Repeat(mp3Frame("", 0), 3)},
+			want:  mp3Frames(4),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.mp3")
+			if err := os.WriteFile(path, bytes.Join(tt.parts, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Mp3Duration(path)
+			if err != nil {
+				t.Fatalf("Mp3Duration: %v", err)
+			}
+			// Scanning sums per-frame durations, each rounded down to the
+			// nanosecond.
+			if diff := got - tt.want; diff < -10*time.Microsecond || diff > 10*time.Microsecond {
+				t.Errorf("Mp3Duration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMp3DurationNoFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mp3")
+	if err := os.WriteFile(path, append([]byte("TAG"), make([]byte, 125)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mp3Duration(path); err == nil {
+		t.Error("Mp3Duration of a file without frames succeeded")
+	}
+}
+
+// mp3Frame160 returns one silent 160 kbps, 44.1 kHz stereo frame, to mix
+// with mp3Frame into a VBR file.
+func mp3Frame160() []byte {
+	f := make([]byte, 144*160000/44100)
+	copy(f, []byte{0xFF, 0xFB, 0xA0, 0x00})
+	return f
+}
+
+func TestMp3DurationEstimate(t *testing.T) {
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	vbr := func(n int) []byte {
+		var b []byte
+		for i := 0; i < n; i++ {
+			if i%3 == 0 {
+				b = append(b, mp3Frame160()...)
+			} else {
+				b = append(b, mp3Frame("", 0)...)
+			}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		parts   [][]byte
+		wantErr time.Duration // the maxErr the estimate should report
+	}{
+		{name: "cbr", parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 200)}, wantErr: 2 * mp3Frames(1)},
+		{name: "cbr shorter than the sniffed frames", parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 10)}, wantErr: 2 * mp3Frames(1)},
+		{name: "cbr with a trailing tag", parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 100), id3v1}, wantErr: 2 * mp3Frames(1)},
+		{name: "cbr with a truncated last frame", parts: [][]byte{bytes.Repeat(mp3Frame("", 0), 100), mp3Frame("", 0)[:100]}, wantErr: 2 * mp3Frames(1)},
+		{name: "vbr is scanned", parts: [][]byte{vbr(100)}},
+		{name: "xing header is trusted", parts: [][]byte{mp3Frame("Xing", 1000), bytes.Repeat(mp3Frame("", 0), 3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.mp3")
+			if err := os.WriteFile(path, bytes.Join(tt.parts, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, maxErr, err := Mp3DurationEstimate(path)
+			if err != nil {
+				t.Fatalf("Mp3DurationEstimate: %v", err)
+			}
+			if maxErr != tt.wantErr {
+				t.Errorf("maxErr = %v, want %v", maxErr, tt.wantErr)
+			}
+			exact, err := Mp3Duration(path)
+			if err != nil {
+				t.Fatalf("Mp3Duration: %v", err)
+			}
+			if diff := (got - exact).Abs(); diff > maxErr {
+				t.Errorf("estimate %v is %v off the exact %v, more than maxErr %v", got, diff, exact, maxErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// vadFloorPercentile: The noise floor is taken at this percentile of the frame levels
+	vadFloorPercentile = 0.1
+)
+
+// VADOptions controls DetectSpeech, an energy and zero-crossing voice
+// activity detector run on the vocal stem before transcription.
+type VADOptions struct {
+	// Enabled: Detect speech regions and write them next to the vocals as <vocals>.vad.json
+	Enabled bool `json:"enabled"`
+	// FrameSeconds: Length of the analysis frames
+	FrameSeconds float64 `json:"frame_seconds"`
+	// ThresholdDB: Frames at least this much louder than the noise floor count as speech
+	ThresholdDB float64 `json:"threshold_db"`
+	// MinThresholdDBFS: Lower bound for the speech threshold, so near digitally silent stems are not
+	// treated as all speech
+	MinThresholdDBFS float64 `json:"min_threshold_dbfs"`
+	// MaxZeroCrossingHz: Loud frames whose zero crossings put their dominant frequency above this are
+	// taken for noise such as hiss or cymbal bleed rather than speech; 0 disables the check. It is in Hz
+	// so it means the same for WAV stems read at their own rate and others decoded at 16 kHz, though
+	// the latter can not show noise above 8 kHz
+	MaxZeroCrossingHz float64 `json:"max_zero_crossing_hz"`
+	// MinSilenceSeconds: Pauses shorter than this do not end a speech region
+	MinSilenceSeconds float64 `json:"min_silence_seconds"`
+	// MinSpeechSeconds: Speech regions shorter than this are dropped as clicks and breaths
+	MinSpeechSeconds float64 `json:"min_speech_seconds"`
+	// PadSeconds: Audio kept around each speech region so word onsets and tails are not clipped
+	PadSeconds float64 `json:"pad_seconds"`
+	// TrimSilence: Transcribe only the speech regions instead of the whole stem, see TranscribeOptions.Speech
+	TrimSilence bool `json:"trim_silence"`
+}
+
+// DefaultVADOptions leaves the stage off; once enabled it trims silence
+// before transcription.
+func DefaultVADOptions() VADOptions {
+	return VADOptions{
+		FrameSeconds:      0.03,
+		ThresholdDB:       10,
+		MinThresholdDBFS:  -50,
+		MaxZeroCrossingHz: 5000,
+		MinSilenceSeconds: 0.5,
+		MinSpeechSeconds:  0.2,
+		PadSeconds:        0.25,
+		TrimSilence:       true,
+	}
+}
+
+// SpeechRegion is a [Start, End) stretch of detected speech, in seconds.
+type SpeechRegion struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// SpeechActivity is the result of DetectSpeech.
+type SpeechActivity struct {
+	// Duration is the length of the analysed audio.
+	Duration float64 `json:"duration"`
+	// ThresholdDBFS is the frame level above which speech was detected.
+	ThresholdDBFS float64        `json:"threshold_dbfs"`
+	Regions       []SpeechRegion `json:"regions"`
+}
+
+// SpeechSeconds is the total length of the speech regions.
+func (s *SpeechActivity) SpeechSeconds() float64 {
+	var total float64
+	for _, r := range s.Regions {
+		total += r.End - r.Start
+	}
+	return total
+}
+
+// Overlap is the length of [start, end) that falls inside speech regions.
+func (s *SpeechActivity) Overlap(start, end float64) float64 {
+	var total float64
+	i := sort.Search(len(s.Regions), func(i int) bool { return s.Regions[i].End > start })
+	for ; i < len(s.Regions) && s.Regions[i].Start < end; i++ {
+		total += min(end, s.Regions[i].End) - max(start, s.Regions[i].Start)
+	}
+	return total
+}
+
+// DetectSpeech finds the speech regions of audio. A frame is speech when
+// its level is ThresholdDB above the noise floor, estimated from the
+// quietest frames, and its zero-crossing frequency is low enough to be
+// speech.
+// Speech frames separated by short pauses are joined into regions, which
+// are padded and merged where the padding makes them overlap.
+func DetectSpeech(ctx context.Context, audio *Audio, opts VADOptions) (*SpeechActivity, error) {
+	r, err := openMono(ctx, audio.Path)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", audio.Path, err)
+	}
+	defer r.Close()
+
+	rate := r.SampleRate()
+	frame := max(int(opts.FrameSeconds*float64(rate)), 2)
+	hop := float64(frame) / float64(rate)
+
+	var (
+		levels    []float64
+		crossings []float64
+		samples   int64
+	)
+	buf := make([]float32, frame)
+	for {
+		n, err := readFullMono(r, buf)
+		if n > 0 {
+			var (
+				power float64
+				zc    int
+			)
+			for i, s := range buf[:n] {
+				power += float64(s) * float64(s)
+				if i > 0 && (s >= 0) != (buf[i-1] >= 0) {
+					zc++
+				}
+			}
+			levels = append(levels, powerDB(power/float64(n)))
+			// A tone of f Hz crosses zero 2f times a second.
+			crossings = append(crossings, float64(zc)*float64(rate)/float64(2*max(n-1, 1)))
+			samples += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", audio.Path, err)
+		}
+	}
+
+	activity := &SpeechActivity{Duration: float64(samples) / float64(rate), ThresholdDBFS: opts.MinThresholdDBFS}
+	if len(levels) == 0 {
+		return activity, nil
+	}
+	sorted := append([]float64(nil), levels...)
+	sort.Float64s(sorted)
+	floor := sorted[int(float64(len(sorted)-1)*vadFloorPercentile)]
+	activity.ThresholdDBFS = max(floor+opts.ThresholdDB, opts.MinThresholdDBFS)
+
+	// Collect runs of speech frames, bridging short pauses.
+	maxGap := int(math.Round(opts.MinSilenceSeconds / hop))
+	var regions []SpeechRegion
+	start, last := -1, -1
+	flush := func() {
+		if start >= 0 {
+			region := SpeechRegion{Start: float64(start) * hop, End: min(float64(last+1)*hop, activity.Duration)}
+			if region.End-region.Start >= opts.MinSpeechSeconds {
+				regions = append(regions, region)
+			}
+		}
+	}
+	for i, level := range levels {
+		if level < activity.ThresholdDBFS || (opts.MaxZeroCrossingHz > 0 && crossings[i] > opts.MaxZeroCrossingHz) {
+			continue
+		}
+		if start < 0 || i-last-1 >= maxGap {
+			flush()
+			start = i
+		}
+		last = i
+	}
+	flush()
+
+	// Pad and merge what the padding joins.
+	for _, region := range regions {
+		region.Start = max(region.Start-opts.PadSeconds, 0)
+		region.End = min(region.End+opts.PadSeconds, activity.Duration)
+		if n := len(activity.Regions); n > 0 && region.Start <= activity.Regions[n-1].End {
+			activity.Regions[n-1].End = region.End
+			continue
+		}
+		activity.Regions = append(activity.Regions, region)
+	}
+	return activity, nil
+}
+
+// SpeechActivityPath is where the speech regions of audio are kept in
+// artifactsDir.
+func SpeechActivityPath(audio *Audio, artifactsDir string) string {
+	base := filepath.Base(audio.Path)
+	return filepath.Join(artifactsDir, strings.TrimSuffix(base, filepath.Ext(base))+".vad.json")
+}
+
+// WriteSpeechActivity stores activity as JSON at path.
+func WriteSpeechActivity(path string, activity *SpeechActivity) error {
+	if err := writeJSON(path, activity); err != nil {
+		return fmt.Errorf("write speech regions: %w", err)
+	}
+	return nil
+}
+
+// ReadSpeechActivity loads speech regions written by WriteSpeechActivity.
+func ReadSpeechActivity(path string) (*SpeechActivity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read speech regions: %w", err)
+	}
+	activity := &SpeechActivity{}
+	if err := json.Unmarshal(data, activity); err != nil {
+		return nil, fmt.Errorf("unmarshal speech regions: %w", err)
+	}
+	return activity, nil
+}
+
+// trimSilence writes the speech regions of src to dst as a mono WAV, one
+// after the other with gap seconds of silence in between.
+func trimSilence(ctx context.Context, src *Audio, activity *SpeechActivity, gap float64, dst string) error {
+	r, err := openMono(ctx, src.Path)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", src.Path, err)
+	}
+	defer r.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		rate := r.SampleRate()
+		w, err := newWAVWriter(out, rate, 1)
+		if err != nil {
+			return err
+		}
+		buf := make([]float32, cutFrameBlock)
+		silence := make([]float32, int(gap*float64(rate)))
+		var pos int64 // samples read from src so far
+		for i, region := range activity.Regions {
+			from := int64(region.Start * float64(rate))
+			to := int64(region.End * float64(rate))
+			for pos < to {
+				n, err := readFullMono(r, buf[:min(int64(len(buf)), to-pos)])
+				if n > 0 {
+					// Copy the part of what was read that lies inside the region.
+					if lo := max(from-pos, 0); lo < int64(n) {
+						if werr := w.WriteFrames(buf[lo:n]); werr != nil {
+							return werr
+						}
+					}
+					pos += int64(n)
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("decode %s: %w", src.Path, err)
+				}
+			}
+			if i+1 < len(activity.Regions) {
+				if err := w.WriteFrames(silence); err != nil {
+					return err
+				}
+			}
+		}
+		return w.Close()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// untrimTranscript moves the timestamps of tat, taken on audio written by
+// trimSilence, back onto the timeline of the original recording. Times that
+// fall into a gap are moved to the end of the region before it.
+func untrimTranscript(tat *TimeAlignedTranscript, activity *SpeechActivity, gap float64) {
+	starts := make([]float64, len(activity.Regions)) // start of each region in the trimmed audio
+	var t float64
+	for i, region := range activity.Regions {
+		starts[i] = t
+		t += region.End - region.Start + gap
+	}
+	untrim := func(t float64) float64 {
+		i := sort.Search(len(starts), func(i int) bool { return starts[i] > t }) - 1
+		if i < 0 {
+			return t
+		}
+		region := activity.Regions[i]
+		return min(region.Start+t-starts[i], region.End)
+	}
+	for s := range tat.Segments {
+		seg := &tat.Segments[s]
+		seg.Start, seg.End = untrim(seg.Start), untrim(seg.End)
+		for k := range seg.Words {
+			if w := &seg.Words[k]; w.End > w.Start {
+				w.Start, w.End = untrim(w.Start), untrim(w.End)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUntrimTranscript(t *testing.T) {
+	// Regions of 2s and 3s, joined with 0.5s gaps: the second one starts at
+	// 2.5s in the trimmed audio.
+	activity := &SpeechActivity{Duration: 20, Regions: []SpeechRegion{{Start: 2, End: 4}, {Start: 10, End: 13}}}
+	word := func(start, end float64, w string) TimeAlignedWord {
+		return TimeAlignedWord{Start: start, End: end, Word: w}
+	}
+
+	tests := []struct {
+		name     string
+		activity *SpeechActivity
+		seg      TranscriptSegment
+		want     TranscriptSegment
+	}{
+		{
+			name:     "first region",
+			activity: activity,
+			seg:      TranscriptSegment{Start: 0.5, End: 1.5, Words: []TimeAlignedWord{word(0.5, 1.5, "a")}},
+			want:     TranscriptSegment{Start: 2.5, End: 3.5, Words: []TimeAlignedWord{word(2.5, 3.5, "a")}},
+		},
+		{
+			name:     "later region",
+			activity: activity,
+			seg:      TranscriptSegment{Start: 3, End: 5, Words: []TimeAlignedWord{word(3, 4, "a"), word(4.2, 5, "b")}},
+			want:     TranscriptSegment{Start: 10.5, End: 12.5, Words: []TimeAlignedWord{word(10.5, 11.5, "a"), word(11.7, 12.5, "b")}},
+		},
+		{
+			name:     "spanning a gap",
+			activity: activity,
+			seg:      TranscriptSegment{Start: 1, End: 3, Words: []TimeAlignedWord{word(1, 2.2, "a"), word(2.6, 3, "b")}},
+			want:     TranscriptSegment{Start: 3, End: 10.5, Words: []TimeAlignedWord{word(3, 4, "a"), word(10.1, 10.5, "b")}},
+		},
+		{
+			name:     "unaligned words are left alone",
+			activity: activity,
+			seg:      TranscriptSegment{Start: 1, End: 3, Words: []TimeAlignedWord{word(1, 2, "a"), word(0, 0, "5")}},
+			want:     TranscriptSegment{Start: 3, End: 10.5, Words: []TimeAlignedWord{word(3, 4, "a"), word(0, 0, "5")}},
+		},
+		{
+			name:     "no regions",
+			activity: &SpeechActivity{Duration: 20},
+			seg:      TranscriptSegment{Start: 1, End: 3, Words: []TimeAlignedWord{word(1, 3, "a")}},
+			want:     TranscriptSegment{Start: 1, End: 3, Words: []TimeAlignedWord{word(1, 3, "a")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tat := &TimeAlignedTranscript{Segments: []TranscriptSegment{tt.seg}}
+			untrimTranscript(tat, tt.activity, 0.5)
+			if got := tat.Segments[0]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("untrimTranscript = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpeechActivityOverlap(t *testing.T) {
+	activity := &SpeechActivity{Duration: 20, Regions: []SpeechRegion{{Start: 2, End: 4}, {Start: 10, End: 13}}}
+
+	tests := []struct {
+		start, end float64
+		want       float64
+	}{
+		{0, 2, 0},
+		{0, 3, 1},
+		{2.5, 3.5, 1},
+		{3, 11, 2},
+		{0, 20, 5},
+		{13, 20, 0},
+	}
+	for _, tt := range tests {
+		if got := activity.Overlap(tt.start, tt.end); got != tt.want {
+			t.Errorf("Overlap(%v, %v) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
@@ -1,29 +1,143 @@
 package scraper
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
-// ExtractVocals separates the vocal stem with Demucs, re‑encodes it to MP3
-// and returns an *Audio describing the result.
+// SeparationMode selects which Demucs model and stem set Separate runs.
+type SeparationMode string
+
+const (
+	// SeparationTwoStems splits into vocals and accompaniment.
+	SeparationTwoStems SeparationMode = "two"
+	// SeparationFourStems splits into vocals, drums, bass and other.
+	SeparationFourStems SeparationMode = "four"
+	// SeparationSixStems adds guitar and piano to the four stems.
+	SeparationSixStems SeparationMode = "six"
+)
+
+// Stem names, as used for the keys of Separate's result and the names of
+// the files it writes.
+const (
+	StemVocals        = "vocals"
+	StemAccompaniment = "accompaniment"
+	StemDrums         = "drums"
+	StemBass          = "bass"
+	StemOther         = "other"
+	StemGuitar        = "guitar"
+	StemPiano         = "piano"
+)
+
+// SeparationOptions controls Separate.
+type SeparationOptions struct {
+	// Mode: Stem set to separate into
+	Mode SeparationMode `json:"mode"`
+	// SegmentStem: Stem cut into segments along the vocal transcript
+	SegmentStem string `json:"segment_stem"`
+	// StemFormat: How stems are stored: FormatWAV keeps Demucs' output untouched, FormatFLAC compresses
+	// it losslessly and FormatMP3 saves the most space at the cost of a lossy re-encode
+	StemFormat Format `json:"stem_format"`
+	// ChunkSeconds: Separate recordings longer than this in overlapping chunks to bound Demucs' memory use;
+	// 0 separates in one go
+	ChunkSeconds float64 `json:"chunk_seconds"`
+	// OverlapSeconds: Audio shared by neighbouring chunks, crossfaded when the stems are stitched
+	OverlapSeconds float64 `json:"overlap_seconds"`
+	// Workers: Chunks separated at the same time
+	Workers int `json:"workers"`
+}
+
+// DefaultSeparationOptions separates vocals from accompaniment, keeps them
+// as WAV and segments the vocals.
+func DefaultSeparationOptions() SeparationOptions {
+	return SeparationOptions{
+		Mode:           SeparationTwoStems,
+		SegmentStem:    StemVocals,
+		StemFormat:     FormatWAV,
+		OverlapSeconds: 5,
+		Workers:        1,
+	}
+}
+
+// demucs returns the model and extra flags for mode, and the stems it
+// produces mapped to the names of the WAVs Demucs writes for them.
+func (mode SeparationMode) demucs() (model string, args []string, stems map[string]string, err error) {
+	switch mode {
+	case "", SeparationTwoStems:
+		return "htdemucs", []string{"--two-stems=vocals"},
+			map[string]string{StemVocals: "vocals", StemAccompaniment: "no_vocals"}, nil
+	case SeparationFourStems:
+		return "htdemucs", nil,
+			map[string]string{StemVocals: "vocals", StemDrums: "drums", StemBass: "bass", StemOther: "other"}, nil
+	case SeparationSixStems:
+		return "htdemucs_6s", nil,
+			map[string]string{StemVocals: "vocals", StemDrums: "drums", StemBass: "bass", StemOther: "other",
+				StemGuitar: "guitar", StemPiano: "piano"}, nil
+	}
+	return "", nil, nil, fmt.Errorf("unknown separation mode %q", mode)
+}
+
+// ExtractVocals separates the vocal stem with Demucs and returns an *Audio
+// describing the resulting vocals.wav.
 func ExtractVocals(
 	ctx context.Context,
 	src *Audio,
 	artifactDir string,
 ) (*Audio, error) {
+	vocals, _, err := SeparateVocals(ctx, src, artifactDir)
+	return vocals, err
+}
+
+// SeparateVocals splits src with Demucs into its vocal stem and the
+// accompaniment (everything else), stores both in artifactDir as vocals.wav
+// and accompaniment.wav, and returns them.
+func SeparateVocals(
+	ctx context.Context,
+	src *Audio,
+	artifactDir string,
+) (vocals, accompaniment *Audio, err error) {
+	stems, err := Separate(ctx, src, artifactDir, DefaultSeparationOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	return stems[StemVocals], stems[StemAccompaniment], nil
+}
+
+// Separate splits src with Demucs into the stems of opts.Mode, stores each
+// as <stem>.<opts.StemFormat> in artifactDir and returns them by stem name.
+func Separate(
+	ctx context.Context,
+	src *Audio,
+	artifactDir string,
+	opts SeparationOptions,
+) (map[string]*Audio, error) {
 	if src == nil {
 		return nil, errors.New("input audio is nil")
 	}
 	if src.Path == "" {
 		return nil, errors.New("input audio path is empty")
 	}
+	model, modeArgs, stemFiles, err := opts.Mode.demucs()
+	if err != nil {
+		return nil, err
+	}
+	format := opts.StemFormat
+	switch format {
+	case "":
+		format = FormatWAV
+	case FormatWAV, FormatFLAC, FormatMP3:
+	default:
+		return nil, fmt.Errorf("unsupported stem format %q", format)
+	}
 
 	absArtifacts, err := filepath.Abs(artifactDir)
 	if err != nil {
@@ -33,18 +147,21 @@ func ExtractVocals(
 		return nil, fmt.Errorf("mkdir artifact dir: %w", err)
 	}
 
-	finalMP3 := filepath.Join(absArtifacts, "vocals.mp3")
+	stemPath := func(stem string) string { return filepath.Join(absArtifacts, stem+"."+string(format)) }
 	// Fast‑path: already done.
-	if _, err := os.Stat(finalMP3); err == nil {
-		dur, derr := Mp3DurationByFrames(finalMP3)
-		if derr != nil {
-			return nil, fmt.Errorf("calc duration: %w", derr)
+	done := true
+	for stem := range stemFiles {
+		if _, err := os.Stat(stemPath(stem)); err != nil {
+			done = false
+			break
 		}
-		return &Audio{Path: finalMP3, Duration: dur, Format: FormatMP3}, nil
+	}
+	if done {
+		return probeStems(ctx, src, stemFiles, stemPath)
 	}
 
 	/* ------------------------------------------------------------------
-	   1. Run Demucs
+	   1. Run Demucs, on the whole recording or in chunks
 	------------------------------------------------------------------ */
 
 	separatedDir := filepath.Join(absArtifacts, "separated")
@@ -52,86 +169,147 @@ func ExtractVocals(
 		return nil, fmt.Errorf("mkdir separated dir: %w", err)
 	}
 
-	fmt.Println("extracting vocals with Demucs …")
-
-	demucsCmd := exec.CommandContext(
-		ctx, "demucs",
-		"--two-stems=vocals",
-		"--out", separatedDir,
-		src.Path,
-	)
-	demucsCmd.Stdout = os.Stdout
-	demucsCmd.Stderr = os.Stderr
+	// Demucs pattern: separated/<model>/<basename>/<stem>.wav
+	base := strings.TrimSuffix(filepath.Base(src.Path), filepath.Ext(src.Path))
+	stemDir := filepath.Join(separatedDir, model, base)
 
-	if out, err := demucsCmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("demucs: %w – %s", err, out)
+	if chunks := planChunks(src.Duration.Seconds(), opts.ChunkSeconds, opts.OverlapSeconds); len(chunks) > 1 {
+		if err := separateChunked(ctx, src, chunks, opts.Workers, model, modeArgs, stemFiles, separatedDir, stemDir); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("separating %d stems with Demucs (%s)", len(stemFiles), model)
+		if err := runDemucs(ctx, model, modeArgs, separatedDir, src.Path); err != nil {
+			return nil, err
+		}
 	}
 
 	/* ------------------------------------------------------------------
-	   2. Locate the generated vocals.wav
+	   2. Store the stems in the requested format
 	------------------------------------------------------------------ */
 
-	base := strings.TrimSuffix(filepath.Base(src.Path), filepath.Ext(src.Path))
-	vocalsWav := ""
-	// Demucs pattern: separated/<model>/<basename>/vocals.wav
-	if err := filepath.WalkDir(separatedDir, func(p string, d fs.DirEntry, _ error) error {
-		if d == nil || d.IsDir() {
-			return nil
+	for stem, file := range stemFiles {
+		wav := filepath.Join(stemDir, file+".wav")
+		if _, err := os.Stat(wav); err != nil {
+			return nil, fmt.Errorf("%s.wav not found for %s: %w", file, base, err)
 		}
-		if strings.EqualFold(d.Name(), "vocals.wav") && strings.HasSuffix(p, filepath.Join(base, "vocals.wav")) {
-			vocalsWav = p
-			return filepath.SkipDir // stop walking
+		if err := storeStem(ctx, wav, stemPath(stem), format); err != nil {
+			return nil, fmt.Errorf("%s: %w", stem, err)
 		}
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("walk separated dir: %w", err)
-	}
-	if vocalsWav == "" {
-		return nil, fmt.Errorf("vocals.wav not found for %s", base)
 	}
 
 	/* ------------------------------------------------------------------
-	   3. Convert WAV → MP3 (into temp file then rename)
+	   3. Gather metadata
 	------------------------------------------------------------------ */
 
-	tmpMP3, err := os.CreateTemp(absArtifacts, "vocals-*.tmp.mp3")
-	if err != nil {
-		return nil, fmt.Errorf("create temp mp3: %w", err)
-	}
-	tmpMP3.Close()
-	defer os.Remove(tmpMP3.Name())
+	return probeStems(ctx, src, stemFiles, stemPath)
+}
 
-	ffmpegCmd := exec.CommandContext(
-		ctx, "ffmpeg",
-		"-y", // overwrite temp file if exists
-		"-i", vocalsWav,
-		"-acodec", "libmp3lame",
-		"-q:a", "0",
-		tmpMP3.Name(),
-	)
-	ffmpegCmd.Stdout = os.Stdout
-	ffmpegCmd.Stderr = os.Stderr
+// runDemucs separates input with model into outDir/<model>/<basename>.
+func runDemucs(ctx context.Context, model string, modeArgs []string, outDir, input string) error {
+	args := append([]string{"-n", model}, modeArgs...)
+	args = append(args, "--out", outDir, input)
+	demucsCmd := exec.CommandContext(ctx, "demucs", args...)
+	// Demucs reports progress on stderr; show it live and keep it for the
+	// error message.
+	var stderr bytes.Buffer
+	demucsCmd.Stdout = os.Stdout
+	demucsCmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 
-	if out, err := ffmpegCmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("ffmpeg: %w – %s", err, out)
+	if err := demucsCmd.Run(); err != nil {
+		return fmt.Errorf("demucs: %w – %s", err, outputTail(stderr.Bytes(), demucsErrorTail))
 	}
+	return nil
+}
 
-	if err := os.Rename(tmpMP3.Name(), finalMP3); err != nil {
-		return nil, fmt.Errorf("rename mp3: %w", err)
+// demucsErrorTail: Bytes of Demucs' stderr kept in its error, enough for the traceback's last lines
+const demucsErrorTail = 2048
+
+// outputTail returns the last n bytes of out, starting at a line boundary
+// where possible.
+func outputTail(out []byte, n int) string {
+	if len(out) <= n {
+		return string(bytes.TrimSpace(out))
+	}
+	out = out[len(out)-n:]
+	if i := bytes.IndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
 	}
+	return "…" + string(bytes.TrimSpace(out))
+}
 
-	/* ------------------------------------------------------------------
-	   4. Gather metadata
-	------------------------------------------------------------------ */
+// probeStems describes the stem files separated from src. They share src's
+// Source.
+func probeStems(ctx context.Context, src *Audio, stemFiles map[string]string, stemPath func(string) string) (map[string]*Audio, error) {
+	stems := make(map[string]*Audio, len(stemFiles))
+	for stem := range stemFiles {
+		a, err := ProbeAudio(ctx, stemPath(stem))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", stem, err)
+		}
+		a.Source = src.Source
+		stems[stem] = a
+	}
+	return stems, nil
+}
 
-	dur, err := Mp3DurationByFrames(finalMP3)
+// storeStem puts the Demucs output wav at dst in format. WAVs are linked, or
+// copied where links are not possible, so the audio is never re-encoded;
+// FLAC and MP3 go through ffmpeg. dst is written via a temp file in its
+// directory so it is never left half written.
+func storeStem(ctx context.Context, wav, dst string, format Format) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "stem-*.tmp."+string(format))
 	if err != nil {
-		return nil, fmt.Errorf("duration: %w", err)
+		return fmt.Errorf("create temp stem: %w", err)
 	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
 
-	return &Audio{
-		Path:     finalMP3,
-		Duration: dur,
-		Format:   FormatMP3,
-	}, nil
+	switch format {
+	case FormatWAV:
+		os.Remove(tmp.Name())
+		if err := os.Link(wav, tmp.Name()); err != nil {
+			if err := copyFile(wav, tmp.Name()); err != nil {
+				return fmt.Errorf("copy wav: %w", err)
+			}
+		}
+	case FormatFLAC:
+		if err := runFFmpeg(ctx, "-y", "-i", wav, "-c:a", "flac", tmp.Name()); err != nil {
+			return err
+		}
+	case FormatMP3:
+		if err := runFFmpeg(ctx, "-y", "-i", wav, "-acodec", "libmp3lame", "-q:a", "0", tmp.Name()); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("rename stem: %w", err)
+	}
+	return nil
+}
+
+func runFFmpeg(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w – %s", err, out)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,283 @@
+package scraper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavHeader describes the fmt and data chunks of a RIFF/WAVE file.
+type wavHeader struct {
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	ByteRate      int
+	BlockAlign    int
+	BitsPerSample int
+	// DataOffset and DataSize locate the sample data inside the file.
+	DataOffset int64
+	DataSize   int64
+}
+
+// Duration is the playing time of the data chunk.
+func (h *wavHeader) Duration() time.Duration {
+	if h.BlockAlign == 0 || h.SampleRate == 0 {
+		return 0
+	}
+	frames := h.DataSize / int64(h.BlockAlign)
+	return time.Duration(frames) * time.Second / time.Duration(h.SampleRate)
+}
+
+// Codec names the sample encoding the way ffprobe would.
+func (h *wavHeader) Codec() string {
+	if h.AudioFormat == wavFormatIEEEFloat {
+		return fmt.Sprintf("pcm_f%dle", h.BitsPerSample)
+	}
+	if h.BitsPerSample == 8 {
+		return "pcm_u8"
+	}
+	return fmt.Sprintf("pcm_s%dle", h.BitsPerSample)
+}
+
+// readWAVHeader walks the RIFF chunks of r up to the start of the data chunk.
+// size is the total size of the file and is used when the data chunk length
+// was never filled in, as happens with WAVs streamed out of ffmpeg.
+func readWAVHeader(r io.ReadSeeker, size int64) (*wavHeader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("read riff header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+
+	h := &wavHeader{}
+	haveFmt := false
+	offset := int64(12)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("read chunk header: %w", err)
+		}
+		offset += 8
+		id := string(chunk[0:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			if length < 16 {
+				return nil, fmt.Errorf("fmt chunk too short: %d bytes", length)
+			}
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, fmt.Errorf("read fmt chunk: %w", err)
+			}
+			h.AudioFormat = binary.LittleEndian.Uint16(buf[0:2])
+			h.Channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+			h.SampleRate = int(binary.LittleEndian.Uint32(buf[4:8]))
+			h.ByteRate = int(binary.LittleEndian.Uint32(buf[8:12]))
+			h.BlockAlign = int(binary.LittleEndian.Uint16(buf[12:14]))
+			h.BitsPerSample = int(binary.LittleEndian.Uint16(buf[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format in the first two
+			// bytes of the sub-format GUID.
+			if h.AudioFormat == wavFormatExtensible && length >= 26 {
+				h.AudioFormat = binary.LittleEndian.Uint16(buf[24:26])
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, errors.New("data chunk before fmt chunk")
+			}
+			h.DataOffset = offset
+			h.DataSize = length
+			if length == 0 || length == 0xFFFFFFFF || offset+length > size {
+				h.DataSize = size - offset
+			}
+			return h, nil
+		default:
+			if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("skip %q chunk: %w", id, err)
+			}
+		}
+
+		offset += length
+		// Chunks are word aligned.
+		if length%2 == 1 {
+			if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("skip pad byte: %w", err)
+			}
+			offset++
+		}
+	}
+}
+
+// wavReader decodes the sample data of a PCM or IEEE float WAV into
+// interleaved float32 frames in [-1, 1].
+type wavReader struct {
+	wavHeader
+	r   io.Reader
+	buf []byte
+}
+
+func newWAVReader(r io.ReadSeeker, size int64) (*wavReader, error) {
+	h, err := readWAVHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case h.AudioFormat == wavFormatPCM && (h.BitsPerSample == 8 || h.BitsPerSample == 16 ||
+		h.BitsPerSample == 24 || h.BitsPerSample == 32):
+	case h.AudioFormat == wavFormatIEEEFloat && (h.BitsPerSample == 32 || h.BitsPerSample == 64):
+	default:
+		return nil, fmt.Errorf("unsupported wav encoding %s", h.Codec())
+	}
+	if h.Channels == 0 || h.BlockAlign != h.Channels*h.BitsPerSample/8 {
+		return nil, fmt.Errorf("inconsistent wav block align %d for %d channels", h.BlockAlign, h.Channels)
+	}
+	if _, err := r.Seek(h.DataOffset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek to wav data: %w", err)
+	}
+	return &wavReader{
+		wavHeader: *h,
+		r:         bufio.NewReaderSize(io.LimitReader(r, h.DataSize), 1<<16),
+	}, nil
+}
+
+// Frames is the number of sample frames in the data chunk.
+func (w *wavReader) Frames() int64 {
+	return w.DataSize / int64(w.BlockAlign)
+}
+
+// ReadFrames decodes up to len(dst)/Channels frames into dst and returns the
+// number of frames read. It returns io.EOF once the data chunk is exhausted.
+func (w *wavReader) ReadFrames(dst []float32) (int, error) {
+	frames := len(dst) / w.Channels
+	need := frames * w.BlockAlign
+	if cap(w.buf) < need {
+		w.buf = make([]byte, need)
+	}
+	n, err := io.ReadFull(w.r, w.buf[:need])
+	frames = n / w.BlockAlign
+	if frames == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	b := w.buf[:frames*w.BlockAlign]
+	samples := frames * w.Channels
+	switch {
+	case w.AudioFormat == wavFormatIEEEFloat && w.BitsPerSample == 32:
+		for i := 0; i < samples; i++ {
+			dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+		}
+	case w.AudioFormat == wavFormatIEEEFloat && w.BitsPerSample == 64:
+		for i := 0; i < samples; i++ {
+			dst[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:])))
+		}
+	case w.BitsPerSample == 8:
+		for i := 0; i < samples; i++ {
+			dst[i] = float32(int(b[i])-128) / 128
+		}
+	case w.BitsPerSample == 16:
+		for i := 0; i < samples; i++ {
+			dst[i] = float32(int16(binary.LittleEndian.Uint16(b[i*2:]))) / (1 << 15)
+		}
+	case w.BitsPerSample == 24:
+		for i := 0; i < samples; i++ {
+			v := int32(b[i*3]) | int32(b[i*3+1])<<8 | int32(int8(b[i*3+2]))<<16
+			dst[i] = float32(v) / (1 << 23)
+		}
+	case w.BitsPerSample == 32:
+		for i := 0; i < samples; i++ {
+			dst[i] = float32(int32(binary.LittleEndian.Uint32(b[i*4:]))) / (1 << 31)
+		}
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return frames, err
+}
+
+// wavWriter encodes interleaved float32 frames as a PCM16 WAV. The RIFF and
+// data sizes are patched in by Close.
+type wavWriter struct {
+	w          io.WriteSeeker
+	bw         *bufio.Writer
+	channels   int
+	sampleRate int
+	dataSize   int64
+	buf        []byte
+}
+
+func newWAVWriter(w io.WriteSeeker, sampleRate, channels int) (*wavWriter, error) {
+	ww := &wavWriter{w: w, bw: bufio.NewWriterSize(w, 1<<16), channels: channels, sampleRate: sampleRate}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (w *wavWriter) writeHeader() error {
+	const bits = 16
+	blockAlign := w.channels * bits / 8
+	var h [44]byte
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(36+w.dataSize))
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(h[22:24], uint16(w.channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(w.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:36], bits)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(w.dataSize))
+	_, err := w.bw.Write(h[:])
+	return err
+}
+
+// WriteFrames encodes interleaved samples, clipping them to [-1, 1].
+func (w *wavWriter) WriteFrames(samples []float32) error {
+	need := len(samples) * 2
+	if cap(w.buf) < need {
+		w.buf = make([]byte, need)
+	}
+	b := w.buf[:need]
+	for i, s := range samples {
+		v := math.Round(float64(s) * (1 << 15))
+		v = max(min(v, math.MaxInt16), math.MinInt16)
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(int16(v)))
+	}
+	n, err := w.bw.Write(b)
+	w.dataSize += int64(n)
+	return err
+}
+
+// Close flushes buffered samples and rewrites the header with final sizes.
+// It does not close the underlying writer.
+func (w *wavWriter) Close() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
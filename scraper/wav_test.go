@@ -0,0 +1,119 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// riffChunk encodes one RIFF chunk, padded to an even length.
+func riffChunk(id string, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body)+1)
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(body)))
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func fmtChunk(format uint16, channels, rate, bits int) []byte {
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint16(body[0:], format)
+	binary.LittleEndian.PutUint16(body[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(body[4:], uint32(rate))
+	binary.LittleEndian.PutUint32(body[8:], uint32(rate*channels*bits/8))
+	binary.LittleEndian.PutUint16(body[12:], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(body[14:], uint16(bits))
+	return riffChunk("fmt ", body)
+}
+
+func extensibleFmtChunk(sub uint16, channels, rate, bits int) []byte {
+	c := fmtChunk(wavFormatExtensible, channels, rate, bits)
+	body := append(c[8:], make([]byte, 24)...)
+	binary.LittleEndian.PutUint16(body[16:], 22) // cbSize
+	binary.LittleEndian.PutUint16(body[24:], sub)
+	return riffChunk("fmt ", body)
+}
+
+func riffFile(chunks ...[]byte) []byte {
+	body := append([]byte("WAVE"), bytes.Join(chunks, nil)...)
+	return riffChunk("RIFF", body)
+}
+
+func TestReadWAVHeader(t *testing.T) {
+	data := make([]byte, 400)
+	// A data chunk whose length was never filled in, as ffmpeg streams it.
+	unsized := riffChunk("data", data)
+	binary.LittleEndian.PutUint32(unsized[4:], 0xFFFFFFFF)
+
+	tests := []struct {
+		name    string
+		file    []byte
+		want    wavHeader
+		wantErr bool
+	}{
+		{
+			name: "pcm16 stereo",
+			file: riffFile(fmtChunk(wavFormatPCM, 2, 44100, 16), riffChunk("data", data)),
+			want: wavHeader{AudioFormat: wavFormatPCM, Channels: 2, SampleRate: 44100, ByteRate: 176400,
+				BlockAlign: 4, BitsPerSample: 16, DataOffset: 44, DataSize: 400},
+		},
+		{
+			name: "odd chunk before data is skipped with its pad byte",
+			file: riffFile(fmtChunk(wavFormatPCM, 1, 16000, 16), riffChunk("LIST", []byte("abc")), riffChunk("data", data)),
+			want: wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 16000, ByteRate: 32000,
+				BlockAlign: 2, BitsPerSample: 16, DataOffset: 56, DataSize: 400},
+		},
+		{
+			name: "extensible float",
+			file: riffFile(extensibleFmtChunk(wavFormatIEEEFloat, 2, 48000, 32), riffChunk("data", data)),
+			want: wavHeader{AudioFormat: wavFormatIEEEFloat, Channels: 2, SampleRate: 48000, ByteRate: 384000,
+				BlockAlign: 8, BitsPerSample: 32, DataOffset: 68, DataSize: 400},
+		},
+		{
+			name: "unsized data chunk runs to the end of the file",
+			file: riffFile(fmtChunk(wavFormatPCM, 1, 8000, 8), unsized),
+			want: wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 8000, ByteRate: 8000,
+				BlockAlign: 1, BitsPerSample: 8, DataOffset: 44, DataSize: 400},
+		},
+		{
+			name:    "not riff",
+			file:    append([]byte("RIFX\x00\x00\x00\x00WAVE"), fmtChunk(wavFormatPCM, 1, 8000, 8)...),
+			wantErr: true,
+		},
+		{
+			name:    "data before fmt",
+			file:    riffFile(riffChunk("data", data), fmtChunk(wavFormatPCM, 1, 8000, 8)),
+			wantErr: true,
+		},
+		{
+			name:    "short fmt chunk",
+			file:    riffFile(riffChunk("fmt ", make([]byte, 12)), riffChunk("data", data)),
+			wantErr: true,
+		},
+		{
+			name:    "no data chunk",
+			file:    riffFile(fmtChunk(wavFormatPCM, 1, 8000, 8)),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readWAVHeader(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readWAVHeader succeeded with %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readWAVHeader: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("readWAVHeader = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -2,19 +2,80 @@ package scraper
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+// Clip selects part of a video. Either Start/End or Chapter must be set; a
+// zero End means "until the end of the video". Chapter titles are matched
+// case-insensitively against the video's chapter markers.
+type Clip struct {
+	Start   time.Duration `json:"start"`
+	End     time.Duration `json:"end"`
+	Chapter string        `json:"chapter,omitempty"`
+}
+
+// Chapter is a chapter marker as reported by yt-dlp.
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start_time"`
+	End   float64 `json:"end_time"`
+}
+
+// VideoInfo is the subset of yt-dlp's metadata we care about.
+type VideoInfo struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// ChannelID is stable across renames, unlike the Channel display name.
+	Channel   string    `json:"channel"`
+	ChannelID string    `json:"channel_id"`
+	Duration  float64   `json:"duration"`
+	Chapters  []Chapter `json:"chapters"`
+}
+
+// FetchVideoInfo asks yt-dlp for the metadata of videoURL without
+// downloading any media.
+func FetchVideoInfo(ctx context.Context, videoURL string) (*VideoInfo, error) {
+	if videoURL == "" {
+		return nil, errors.New("videoURL cannot be empty")
+	}
+
+	cmd := exec.CommandContext(ctx, "yt-dlp",
+		"--dump-single-json",
+		"--skip-download",
+		"--no-playlist",
+		videoURL,
+	)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("yt-dlp: %w", err)
+	}
+
+	info := &VideoInfo{}
+	if err := json.Unmarshal(out, info); err != nil {
+		return nil, fmt.Errorf("decode video info: %w", err)
+	}
+	return info, nil
+}
+
 // DownloadYoutubeAudio downloads the best‑quality audio track of a YouTube
 // video, converts it to MP3 (via yt‑dlp + ffmpeg) and returns metadata.
 //
 // The caller controls cancellation with ctx.  The function is idempotent:
-// if outputPath already exists it simply calculates duration and returns.
+// if outputPath already holds this video it simply calculates duration and
+// returns.
 func DownloadYoutubeAudio(
 	ctx context.Context,
 	videoURL string,
@@ -23,7 +84,102 @@ func DownloadYoutubeAudio(
 	if videoURL == "" {
 		return nil, errors.New("videoURL cannot be empty")
 	}
+	return download(ctx, videoURL, outputPath, Clip{})
+}
+
+// DownloadYoutubeClip is like DownloadYoutubeAudio but only fetches the part
+// of the video selected by clip. The returned Audio's Source.Offset is the
+// clip's start within the original video.
+func DownloadYoutubeClip(
+	ctx context.Context,
+	videoURL string,
+	outputPath string,
+	clip Clip,
+) (*Audio, error) {
+	resolved, err := resolveClips(ctx, videoURL, []Clip{clip})
+	if err != nil {
+		return nil, err
+	}
+	return download(ctx, videoURL, outputPath, resolved[0])
+}
+
+// DownloadYoutubeClips downloads every clip of videoURL into outputDir, one
+// MP3 per clip, resolving chapter names with a single metadata lookup.
+func DownloadYoutubeClips(
+	ctx context.Context,
+	videoURL string,
+	outputDir string,
+	clips []Clip,
+) ([]*Audio, error) {
+	resolved, err := resolveClips(ctx, videoURL, clips)
+	if err != nil {
+		return nil, err
+	}
+
+	audios := make([]*Audio, 0, len(resolved))
+	for i, c := range resolved {
+		name := fmt.Sprintf("clip_%03d_%.2fs_%.2fs.mp3", i, c.Start.Seconds(), c.End.Seconds())
+		a, err := download(ctx, videoURL, filepath.Join(outputDir, name), c)
+		if err != nil {
+			return nil, fmt.Errorf("clip %d: %w", i, err)
+		}
+		audios = append(audios, a)
+	}
+	return audios, nil
+}
+
+// resolveClips validates clips and turns chapter selections into time ranges.
+// Video metadata is only fetched if at least one clip names a chapter.
+func resolveClips(ctx context.Context, videoURL string, clips []Clip) ([]Clip, error) {
+	if videoURL == "" {
+		return nil, errors.New("videoURL cannot be empty")
+	}
+
+	var info *VideoInfo
+	resolved := make([]Clip, 0, len(clips))
+	for i, c := range clips {
+		if c.Chapter == "" {
+			if c.Start < 0 || (c.End != 0 && c.End <= c.Start) {
+				return nil, fmt.Errorf("clip %d: invalid range %s-%s", i, c.Start, c.End)
+			}
+			resolved = append(resolved, c)
+			continue
+		}
+
+		if info == nil {
+			var err error
+			if info, err = FetchVideoInfo(ctx, videoURL); err != nil {
+				return nil, err
+			}
+		}
+		if len(info.Chapters) == 0 {
+			return nil, fmt.Errorf("clip %d: %s has no chapter markers", i, videoURL)
+		}
+		ch, ok := findChapter(info.Chapters, c.Chapter)
+		if !ok {
+			return nil, fmt.Errorf("clip %d: chapter %q not found", i, c.Chapter)
+		}
+		resolved = append(resolved, Clip{
+			Start:   secondsToDuration(ch.Start),
+			End:     secondsToDuration(ch.End),
+			Chapter: ch.Title,
+		})
+	}
+	return resolved, nil
+}
 
+func findChapter(chapters []Chapter, title string) (Chapter, bool) {
+	for _, ch := range chapters {
+		if strings.EqualFold(strings.TrimSpace(ch.Title), strings.TrimSpace(title)) {
+			return ch, true
+		}
+	}
+	return Chapter{}, false
+}
+
+// download fetches videoURL (or the range described by clip) into outputPath.
+// A zero clip downloads the whole video.
+func download(ctx context.Context, videoURL, outputPath string, clip Clip) (*Audio, error) {
 	// Resolve absolute path and create parent directory.
 	absOut, err := filepath.Abs(outputPath)
 	if err != nil {
@@ -32,14 +188,22 @@ func DownloadYoutubeAudio(
 	if err = os.MkdirAll(filepath.Dir(absOut), fs.ModePerm); err != nil {
 		return nil, fmt.Errorf("mkdir output dir: %w", err)
 	}
+	source := Source{URL: videoURL, Offset: clip.Start, Chapter: clip.Chapter}
 
-	// Fast‑path: file already present.
+	record := downloadRecord{URL: videoURL, Clip: clip}
+
+	// Fast‑path: file already present and downloaded from the same range.
 	if _, err := os.Stat(absOut); err == nil {
-		dur, derr := Mp3DurationByFrames(absOut)
-		if derr != nil {
-			return nil, fmt.Errorf("calc duration: %w", derr)
+		if prev, err := readDownloadRecord(absOut); err == nil && prev == record {
+			// A cache hit only needs the duration to within a couple of
+			// frames, so headerless CBR files are not scanned.
+			dur, _, derr := Mp3DurationEstimate(absOut)
+			if derr != nil {
+				return nil, fmt.Errorf("calc duration: %w", derr)
+			}
+			return &Audio{Path: absOut, Duration: dur, Format: FormatMP3, Source: source}, nil
 		}
-		return &Audio{Path: absOut, Duration: dur, Format: FormatMP3}, nil
+		log.Printf("%s holds a different download, fetching %s again", absOut, videoURL)
 	}
 
 	// Download to a temp file then atomically rename.
@@ -55,8 +219,18 @@ func DownloadYoutubeAudio(
 		"--audio-format", "mp3",
 		"--audio-quality", "0",
 		"--output", tmp.Name(),
-		videoURL,
 	}
+	if clip != (Clip{}) {
+		end := "inf"
+		if clip.End > 0 {
+			end = fmt.Sprintf("%.3f", clip.End.Seconds())
+		}
+		args = append(args,
+			"--download-sections", fmt.Sprintf("*%.3f-%s", clip.Start.Seconds(), end),
+			"--force-keyframes-at-cuts",
+		)
+	}
+	args = append(args, videoURL)
 
 	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
 	out, err := cmd.CombinedOutput()
@@ -68,8 +242,11 @@ func DownloadYoutubeAudio(
 	if err := os.Rename(tmp.Name(), absOut); err != nil {
 		return nil, fmt.Errorf("rename temp file: %w", err)
 	}
+	if err := writeJSON(downloadRecordPath(absOut), record); err != nil {
+		return nil, fmt.Errorf("write download record: %w", err)
+	}
 
-	dur, err := Mp3DurationByFrames(absOut)
+	dur, err := Mp3Duration(absOut)
 	if err != nil {
 		return nil, fmt.Errorf("calc duration: %w", err)
 	}
@@ -78,5 +255,78 @@ func DownloadYoutubeAudio(
 		Path:     absOut,
 		Duration: dur,
 		Format:   FormatMP3,
+		Source:   source,
 	}, nil
 }
+
+// downloadRecord is kept next to every download so that a later run only
+// reuses the file for the same video and range.
+type downloadRecord struct {
+	URL  string `json:"url"`
+	Clip Clip   `json:"clip"`
+}
+
+func downloadRecordPath(path string) string { return path + ".source.json" }
+
+func readDownloadRecord(path string) (downloadRecord, error) {
+	var rec downloadRecord
+	data, err := os.ReadFile(downloadRecordPath(path))
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// DownloadName is a file system friendly name for the audio of clip of
+// videoURL: the YouTube video id, or a digest of the URL for other sites,
+// followed by the clip range or chapter. Distinct sources get distinct
+// names, so their artifacts never mix.
+func DownloadName(videoURL string, clip Clip) string {
+	name := youtubeID(videoURL)
+	if name == "" {
+		sum := sha256.Sum256([]byte(videoURL))
+		name = "url-" + hex.EncodeToString(sum[:4])
+	}
+	switch {
+	case clip.Chapter != "":
+		sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(clip.Chapter))))
+		name += "_chapter-" + hex.EncodeToString(sum[:4])
+	case clip != (Clip{}):
+		end := "end"
+		if clip.End > 0 {
+			end = fmt.Sprintf("%.3fs", clip.End.Seconds())
+		}
+		name += fmt.Sprintf("_%.3fs-%s", clip.Start.Seconds(), end)
+	}
+	return name
+}
+
+// youtubeID extracts the video id from the usual forms of YouTube URLs, or
+// returns "" if videoURL is not one of them.
+func youtubeID(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	var id string
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch {
+	case host == "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case host == "youtube.com" || strings.HasSuffix(host, ".youtube.com"):
+		if id = u.Query().Get("v"); id == "" {
+			for _, prefix := range []string{"/shorts/", "/embed/", "/live/"} {
+				if rest, ok := strings.CutPrefix(u.Path, prefix); ok {
+					id = strings.Trim(rest, "/")
+				}
+			}
+		}
+	}
+	for _, r := range id {
+		if !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return ""
+		}
+	}
+	return id
+}